@@ -0,0 +1,128 @@
+package chadmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/NextChapterSoftware/chissl/share/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var twoFactorHelp = `
+  Usage: chissl admin 2fa [subcommand] [options]
+
+  Subcommands:
+    enroll - Enables a TOTP second factor for the admin of the profile,
+             printing an otpauth URI to import into an authenticator app
+             and asking for a code generated with it
+    session - Exchanges a code for a short-lived session token, printed
+              on stdout, to use with --session or CHISEL_ADMIN_SESSION
+    disable - Disables the second factor of the admin of the profile,
+              or of another admin with --username
+
+  Once enrolled, every admin command requires either --otp <code>
+  or --session <token>, e.g.
+
+    chissl admin --otp 123456 listusers
+    export CHISEL_ADMIN_SESSION=$(chissl admin --otp 123456 2fa session)
+    chissl admin listusers
+`
+
+// TwoFactor manages the TOTP second factor of admins
+func (c *AdminClient) TwoFactor(args []string) {
+	if len(args) == 0 {
+		fmt.Print(twoFactorHelp)
+		os.Exit(0)
+	}
+	switch args[0] {
+	case "enroll":
+		c.enroll(args[1:])
+	case "session":
+		c.session(args[1:])
+	case "disable":
+		c.disable(args[1:])
+	default:
+		fmt.Print(twoFactorHelp)
+		os.Exit(0)
+	}
+}
+
+func (c *AdminClient) enroll(args []string) {
+	flags := flag.NewFlagSet("enroll", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(twoFactorHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	u, err := url.JoinPath(c.server, "/2fa/enroll")
+	fatalError(&err)
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodPost, u, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	enrollment := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{}
+	err = json.Unmarshal([]byte(result), &enrollment)
+	fatalError(&err)
+
+	fmt.Printf("Add this account to your authenticator app:\n\n  %s\n\nor enter the secret %s\n\n", enrollment.URI, enrollment.Secret)
+	fmt.Print("Code: ")
+	code, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fatalError(&err)
+
+	u, err = url.JoinPath(c.server, "/2fa/confirm")
+	fatalError(&err)
+	body, err := json.Marshal(map[string]string{"code": strings.TrimSpace(code)})
+	fatalError(&err)
+	_, err = utils.HttpRequestWithBodyWithBasicAuth(http.MethodPost, u, string(body), c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	log.Printf("Success: Two-factor authentication enabled for %s", c.config.Username)
+}
+
+func (c *AdminClient) session(args []string) {
+	flags := flag.NewFlagSet("session", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(twoFactorHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	u, err := url.JoinPath(c.server, "/2fa/session")
+	fatalError(&err)
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodPost, u, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	session := struct {
+		Token   string `json:"token"`
+		Expires string `json:"expires"`
+	}{}
+	err = json.Unmarshal([]byte(result), &session)
+	fatalError(&err)
+	log.Printf("Session valid until %s", session.Expires)
+	fmt.Println(session.Token)
+}
+
+func (c *AdminClient) disable(args []string) {
+	flags := flag.NewFlagSet("disable", flag.ExitOnError)
+	username := flags.String("username", "", "")
+	flags.StringVar(username, "u", "", "")
+	flags.Usage = func() {
+		fmt.Print(twoFactorHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	u, err := url.JoinPath(c.server, "/2fa", *username)
+	fatalError(&err)
+	_, err = utils.HttpRequestNoBodyWithBasicAuth(http.MethodDelete, u, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	if *username == "" {
+		*username = c.config.Username
+	}
+	log.Printf("Success: Two-factor authentication disabled for %s", *username)
+}
@@ -0,0 +1,145 @@
+package chadmin
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/utils"
+	"github.com/olekukonko/tablewriter"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+var groupListHelp = `
+  Usage: chissl admin listgroups
+
+  Flags:
+	--raw,     Flag to output the raw JSON response
+`
+
+func (c *AdminClient) ListGroups(args []string) {
+	flags := flag.NewFlagSet("listgroups", flag.ExitOnError)
+	rawOutput := flags.Bool("raw", false, "")
+	flags.Usage = func() {
+		fmt.Print(groupListHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	url, err := url.JoinPath(c.server, "/groups")
+	fatalError(&err)
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodGet, url, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+
+	if *rawOutput {
+		fmt.Println(result)
+		os.Exit(0)
+	}
+	groups := []*settings.Group{}
+	err = json.Unmarshal([]byte(result), &groups)
+	fatalError(&err)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Roles", "Addresses", "Socks"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	for _, g := range groups {
+		var addrStrings []string
+		for _, addr := range g.Addrs {
+			addrStrings = append(addrStrings, addr.String())
+		}
+		table.Append([]string{g.Name, joinStrings(g.Roles, ", "), joinStrings(addrStrings, ", "), fmt.Sprint(g.AllowSocks)})
+	}
+	table.Render()
+}
+
+var groupSetHelp = `
+  Usage: chissl admin setgroup [options]
+
+  Adds a group, or replaces an existing one. Members of a group
+  inherit its roles, addresses and allow/deny lists.
+
+  Options:
+    --name, -n      Name of the group
+    --roles         Comma-separated roles: admin, user-manager, viewer, tunnel-only
+	--addresses,-a  Comma-separated list of regex expressions
+    --allow         Comma-separated CIDRs which may reach the members' remotes
+    --deny          Comma-separated CIDRs which may not reach the members' remotes
+
+  Flags:
+	--socks, 		Flag to allow the members to expose SOCKS remotes
+`
+
+func (c *AdminClient) SetGroup(args []string) {
+	flags := flag.NewFlagSet("setgroup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(groupSetHelp)
+		os.Exit(0)
+	}
+	var regexList RegexList
+	name := flags.String("name", "", "")
+	flags.StringVar(name, "n", "", "")
+	roles := flags.String("roles", "", "")
+	flags.Var(&regexList, "addresses", "")
+	flags.Var(&regexList, "a", "")
+	allow := flags.String("allow", "", "")
+	deny := flags.String("deny", "", "")
+	allowSocks := flags.Bool("socks", false, "")
+	flags.Parse(args)
+
+	g := &settings.Group{
+		Name:       *name,
+		Addrs:      regexList.expressions,
+		AllowSocks: *allowSocks,
+	}
+	if *roles != "" {
+		g.Roles = strings.Split(*roles, ",")
+	}
+	if *allow != "" {
+		g.Allow = strings.Split(*allow, ",")
+	}
+	if *deny != "" {
+		g.Deny = strings.Split(*deny, ",")
+	}
+	err := g.ValidateGroup()
+	fatalError(&err)
+
+	body, err := json.Marshal(g)
+	fatalError(&err)
+	url, err := url.JoinPath(c.server, "/group")
+	fatalError(&err)
+	_, err = utils.HttpRequestWithBodyWithBasicAuth(http.MethodPut, url, string(body), c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	log.Printf("Success: Group %s was saved", g.Name)
+}
+
+var groupDelHelp = `
+  Usage: chissl admin delgroup [options]
+
+  Options:
+    --name, -n  Name of the group to delete, which must have no members
+`
+
+func (c *AdminClient) DelGroup(args []string) {
+	flags := flag.NewFlagSet("delgroup", flag.ExitOnError)
+	name := flags.String("name", "", "")
+	flags.StringVar(name, "n", "", "")
+	flags.Usage = func() {
+		fmt.Print(groupDelHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+	if *name == "" {
+		flags.Usage()
+	}
+
+	url, err := url.JoinPath(c.server, "/group", *name)
+	fatalError(&err)
+	_, err = utils.HttpRequestNoBodyWithBasicAuth(http.MethodDelete, url, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	log.Printf("Group %s deleted", *name)
+}
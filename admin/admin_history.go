@@ -0,0 +1,98 @@
+package chadmin
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/utils"
+	"github.com/olekukonko/tablewriter"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+var historyHelp = `
+  Usage: chissl admin history
+
+  Lists the previous versions of the server's users, newest
+  first, which 'chissl admin rollback' can restore.
+
+  Flags:
+	--raw,          Flag to output the raw JSON response
+`
+
+func (c *AdminClient) History(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	rawOutput := flags.Bool("raw", false, "")
+	flags.Usage = func() {
+		fmt.Print(historyHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	url, err := url.JoinPath(c.server, "/authfile/history")
+	fatalError(&err)
+
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodGet, url, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+
+	if *rawOutput {
+		fmt.Println(result)
+		os.Exit(0)
+	}
+
+	versions := []*settings.AuthFileVersion{}
+	err = json.Unmarshal([]byte(result), &versions)
+	fatalError(&err)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Revision", "Time", "Users", "Groups"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	for _, v := range versions {
+		t := ""
+		if !v.Time.IsZero() {
+			t = v.Time.Local().Format(time.RFC3339)
+		}
+		table.Append([]string{fmt.Sprint(v.Revision), t, fmt.Sprint(v.Users), fmt.Sprint(v.Groups)})
+	}
+	table.Render()
+}
+
+var rollbackHelp = `
+  Usage: chissl admin rollback [options]
+
+  Restores a previous version of the server's users, listed by
+  'chissl admin history'. The version replaced is kept, so a
+  rollback can be undone too.
+
+  Options:
+    --revision, -r  The revision to restore
+`
+
+func (c *AdminClient) Rollback(args []string) {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	revision := flags.Int64("revision", -1, "")
+	flags.Int64Var(revision, "r", -1, "")
+	flags.Usage = func() {
+		fmt.Print(rollbackHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	if *revision < 0 {
+		flags.Usage()
+	}
+
+	url, err := url.JoinPath(c.server, "/authfile/rollback", strconv.FormatInt(*revision, 10))
+	fatalError(&err)
+
+	_, err = utils.HttpRequestNoBodyWithBasicAuth(http.MethodPost, url, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+
+	log.Printf("Success: Users rolled back to revision %d", *revision)
+}
@@ -0,0 +1,91 @@
+package chadmin
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var inviteHelp = `
+  Usage: chissl admin invite [options]
+
+  Creates a one-time invitation token, printed on stdout. Redeeming it
+  with 'chissl client enroll <token>' creates the user, with a generated
+  password, and writes the client's profile. Tokens are signed with a
+  key derived from the server's key, so they only survive a restart of
+  a server started with --keyfile or --key.
+
+  Options:
+    --username, -u  Username of the invited user (defaults to a generated one)
+    --addresses,-a  Comma-separated list of regex expressions
+    --ttl           How long the user may log in for, e.g. 7d or 36h
+                    (defaults to no expiry)
+    --valid         How long the token may be redeemed for (defaults to 24h)
+    --server        The server URL clients redeem the token with
+                    (defaults to the server of the profile)
+
+  Flags:
+	--socks, 		Flag to allow the user to expose SOCKS remotes
+`
+
+func (c *AdminClient) Invite(args []string) {
+	flags := flag.NewFlagSet("invite", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(inviteHelp)
+		os.Exit(0)
+	}
+	var regexList RegexList
+	username := flags.String("username", "", "")
+	flags.StringVar(username, "u", "", "")
+	flags.Var(&regexList, "addresses", "")
+	flags.Var(&regexList, "a", "")
+	ttl := flags.String("ttl", "", "")
+	valid := flags.String("valid", "24h", "")
+	server := flags.String("server", c.server, "")
+	allowSocks := flags.Bool("socks", false, "")
+	flags.Parse(args)
+
+	now := time.Now()
+	inv := &settings.Invite{
+		Server:     *server,
+		Username:   *username,
+		AllowSocks: *allowSocks,
+	}
+	for _, re := range regexList.expressions {
+		inv.Addrs = append(inv.Addrs, re.String())
+	}
+	if len(inv.Addrs) == 0 {
+		log.Fatal("at least one address must be provided")
+	}
+	if *ttl != "" {
+		d, err := settings.ParseTTL(*ttl)
+		fatalError(&err)
+		expires := now.Add(d)
+		inv.ExpiresAt = &expires
+	}
+	d, err := settings.ParseTTL(*valid)
+	fatalError(&err)
+	inv.RedeemBy = now.Add(d)
+
+	body, err := json.Marshal(inv)
+	fatalError(&err)
+	u, err := url.JoinPath(c.server, "/invite")
+	fatalError(&err)
+	result, err := utils.HttpRequestWithBodyWithBasicAuth(http.MethodPost, u, string(body), c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal([]byte(result), &resp)
+	fatalError(&err)
+
+	log.Printf("Invitation valid until %s, redeem it with 'chissl client enroll <token>'", inv.RedeemBy.Format(time.RFC3339))
+	fmt.Println(resp.Token)
+}
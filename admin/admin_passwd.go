@@ -0,0 +1,106 @@
+package chadmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/NextChapterSoftware/chissl/share/utils"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var passwdHelp = `
+  Usage: chissl admin passwd [options]
+
+  Changes the password of the user of the profile. The new password
+  is prompted for, without echoing it, when not given. Remember to
+  update the auth of your profile afterwards.
+
+  Options:
+    --password, -p  The new password, meant for scripts only, as it is
+                    left in the shell history and visible to ps
+`
+
+func (c *AdminClient) Passwd(args []string) {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	password := flags.String("password", "", "")
+	flags.StringVar(password, "p", "", "")
+	flags.Usage = func() {
+		fmt.Print(passwdHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	if *password == "" {
+		p, err := ReadNewPassword(os.Stdin)
+		fatalError(&err)
+		*password = p
+	}
+	err := ChangePassword(c.server, c.config.Username, c.config.Password, *password, c.config.Headers)
+	fatalError(&err)
+	log.Printf("Success: Password changed for %s", c.config.Username)
+}
+
+// ReadNewPassword prompts for a new password, twice, without
+// echoing it when in is a terminal
+func ReadNewPassword(in io.Reader) (string, error) {
+	read := readLine(bufio.NewReader(in))
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		read = func() (string, error) {
+			b, err := term.ReadPassword(int(f.Fd()))
+			fmt.Println()
+			return string(b), err
+		}
+	}
+	fmt.Print("New password: ")
+	p1, err := read()
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("Retype new password: ")
+	p2, err := read()
+	if err != nil {
+		return "", err
+	}
+	if p1 != p2 {
+		return "", errors.New("passwords do not match")
+	}
+	return p1, nil
+}
+
+// readLine reads lines from r, without their line ending
+func readLine(r *bufio.Reader) func() (string, error) {
+	return func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// ChangePassword changes the password of a user through the
+// REST API of server, authenticating with its current password
+func ChangePassword(server, username, current, password string, headers http.Header) error {
+	body, err := json.Marshal(map[string]string{
+		"current_password": current,
+		"new_password":     password,
+	})
+	if err != nil {
+		return err
+	}
+	u, err := url.JoinPath(server, "/me/password")
+	if err != nil {
+		return err
+	}
+	_, err = utils.HttpRequestWithBodyWithBasicAuth(http.MethodPut, u, string(body), username, current, headers)
+	return err
+}
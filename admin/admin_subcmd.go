@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 func fatalError(err *error) {
@@ -27,9 +28,16 @@ var userAddHelp = `
     --username, -u  Username for the new user
     --password, -p  Password for the new user
 	--addresses,-a  Comma-separated list of regex expressions
+    --allow         Comma-separated CIDRs which may reach the user's remotes
+    --deny          Comma-separated CIDRs which may not reach the user's remotes
+    --ttl           How long the user may log in for, e.g. 7d or 36h
+    --roles         Comma-separated roles: admin, user-manager, viewer, tunnel-only
+    --groups        Comma-separated groups the user inherits roles and addresses from
   
   Flags:
 	--admin, 		Flag to add admin permission to user 
+	--socks, 		Flag to allow the user to expose SOCKS remotes
+	--disabled, 	Flag to add the user disabled
 `
 
 func (c *AdminClient) AddUser(args []string) {
@@ -49,6 +57,13 @@ func (c *AdminClient) AddUser(args []string) {
 	flags.Var(&regexList, "addresses", "Comma-separated list of regex expressions")
 	flags.Var(&regexList, "a", "Comma-separated list of regex expressions")
 	isAdmin := flags.Bool("admin", false, "")
+	allowSocks := flags.Bool("socks", false, "")
+	allow := flags.String("allow", "", "")
+	deny := flags.String("deny", "", "")
+	ttl := flags.String("ttl", "", "")
+	disabled := flags.Bool("disabled", false, "")
+	roles := flags.String("roles", "", "")
+	groups := flags.String("groups", "", "")
 
 	err := flags.Parse(args)
 	if err != nil {
@@ -57,10 +72,30 @@ func (c *AdminClient) AddUser(args []string) {
 	}
 
 	user := &settings.User{
-		Name:    username,
-		Pass:    password,
-		IsAdmin: *isAdmin,
-		Addrs:   regexList.expressions,
+		Name:       username,
+		Pass:       password,
+		IsAdmin:    *isAdmin,
+		AllowSocks: *allowSocks,
+		Addrs:      regexList.expressions,
+		Disabled:   *disabled,
+	}
+	if *ttl != "" {
+		d, err := settings.ParseTTL(*ttl)
+		fatalError(&err)
+		expires := time.Now().Add(d)
+		user.ExpiresAt = &expires
+	}
+	if *roles != "" {
+		user.Roles = strings.Split(*roles, ",")
+	}
+	if *groups != "" {
+		user.Groups = strings.Split(*groups, ",")
+	}
+	if *allow != "" {
+		user.Allow = strings.Split(*allow, ",")
+	}
+	if *deny != "" {
+		user.Deny = strings.Split(*deny, ",")
 	}
 
 	err = user.ValidateUser()
@@ -78,6 +113,7 @@ func (c *AdminClient) AddUser(args []string) {
 		userJSON,
 		c.config.Username,
 		c.config.Password,
+		c.config.Headers,
 	)
 	fatalError(&err)
 
@@ -114,6 +150,7 @@ func (c *AdminClient) DelUser(args []string) {
 		url,
 		c.config.Username,
 		c.config.Password,
+		c.config.Headers,
 	)
 	fatalError(&err)
 
@@ -155,6 +192,7 @@ func (c *AdminClient) GerUser(args []string) {
 		url,
 		c.config.Username,
 		c.config.Password,
+		c.config.Headers,
 	)
 	fatalError(&err)
 
@@ -167,7 +205,7 @@ func (c *AdminClient) GerUser(args []string) {
 
 	// Create a new table writer and set it to write to Stdout
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Is_Admin", "Addresses"})
+	table.SetHeader([]string{"Name", "Is_Admin", "Roles", "Groups", "Addresses"})
 
 	// Add a border around the table
 	table.SetBorder(true)
@@ -177,7 +215,7 @@ func (c *AdminClient) GerUser(args []string) {
 	for _, addr := range user.Addrs {
 		addrStrings = append(addrStrings, addr.String())
 	}
-	table.Append([]string{user.Name, fmt.Sprint(user.IsAdmin), joinStrings(addrStrings, ", ")})
+	table.Append([]string{user.Name, fmt.Sprint(user.IsAdmin), joinStrings(user.Roles, ", "), joinStrings(user.Groups, ", "), joinStrings(addrStrings, ", ")})
 
 	// Render the table
 	table.Render()
@@ -208,7 +246,7 @@ func (c *AdminClient) ListUsers(args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodGet, url, c.config.Username, c.config.Password)
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodGet, url, c.config.Username, c.config.Password, c.config.Headers)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -225,7 +263,7 @@ func (c *AdminClient) ListUsers(args []string) {
 
 	// Create a new table writer and set it to write to Stdout
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Is_Admin", "Addresses"})
+	table.SetHeader([]string{"Name", "Is_Admin", "Roles", "Groups", "Addresses"})
 
 	// Add a border around the table
 	table.SetBorder(true)
@@ -238,7 +276,7 @@ func (c *AdminClient) ListUsers(args []string) {
 		for _, addr := range user.Addrs {
 			addrStrings = append(addrStrings, addr.String())
 		}
-		table.Append([]string{user.Name, fmt.Sprint(user.IsAdmin), joinStrings(addrStrings, ", ")})
+		table.Append([]string{user.Name, fmt.Sprint(user.IsAdmin), joinStrings(user.Roles, ", "), joinStrings(user.Groups, ", "), joinStrings(addrStrings, ", ")})
 	}
 
 	// Render the table
@@ -249,3 +287,97 @@ func (c *AdminClient) ListUsers(args []string) {
 func joinStrings(strs []string, sep string) string {
 	return strings.Join(strs, sep)
 }
+
+var lockoutsHelp = `
+  Usage: chissl admin lockouts [options]
+
+  Lists the IPs and usernames with recent failed logins, locked
+  out IPs and throttled usernames first.
+
+  Flags:
+	--raw,          Flag to output the raw JSON response
+`
+
+func (c *AdminClient) Lockouts(args []string) {
+	flags := flag.NewFlagSet("lockouts", flag.ExitOnError)
+	rawOutput := flags.Bool("raw", false, "")
+	flags.Usage = func() {
+		fmt.Print(lockoutsHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	url, err := url.JoinPath(c.server, "/lockouts")
+	fatalError(&err)
+
+	result, err := utils.HttpRequestNoBodyWithBasicAuth(http.MethodGet, url, c.config.Username, c.config.Password, c.config.Headers)
+	fatalError(&err)
+
+	if *rawOutput {
+		fmt.Println(result)
+		os.Exit(0)
+	}
+
+	entries := []*settings.LockoutEntry{}
+	err = json.Unmarshal([]byte(result), &entries)
+	fatalError(&err)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Key", "Failures", "Last_Failure", "Locked_Until"})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+	for _, e := range entries {
+		until := ""
+		if !e.Until.IsZero() {
+			until = e.Until.Local().Format(time.RFC3339)
+		}
+		table.Append([]string{e.Kind, e.Key, fmt.Sprint(e.Failures), e.Last.Local().Format(time.RFC3339), until})
+	}
+	table.Render()
+}
+
+var unlockHelp = `
+  Usage: chissl admin unlock [options]
+
+  Options:
+    --ip            IP whose failed logins are cleared
+    --user, -u      Username whose failed logins are cleared
+
+  Flags:
+	--all,          Flag to clear all failed logins
+`
+
+func (c *AdminClient) Unlock(args []string) {
+	flags := flag.NewFlagSet("unlock", flag.ExitOnError)
+	ip := flags.String("ip", "", "")
+	username := flags.String("user", "", "")
+	flags.StringVar(username, "u", "", "")
+	all := flags.Bool("all", false, "")
+	flags.Usage = func() {
+		fmt.Print(unlockHelp)
+		os.Exit(0)
+	}
+	flags.Parse(args)
+
+	var paths [][]string
+	if *all {
+		paths = append(paths, []string{"/lockouts"})
+	}
+	if *ip != "" {
+		paths = append(paths, []string{"/lockouts", settings.LockoutIP, *ip})
+	}
+	if *username != "" {
+		paths = append(paths, []string{"/lockouts", settings.LockoutUser, *username})
+	}
+	if len(paths) == 0 {
+		flags.Usage()
+	}
+
+	for _, p := range paths {
+		url, err := url.JoinPath(c.server, p...)
+		fatalError(&err)
+		_, err = utils.HttpRequestNoBodyWithBasicAuth(http.MethodDelete, url, c.config.Username, c.config.Password, c.config.Headers)
+		fatalError(&err)
+	}
+	log.Printf("Success: Lockouts cleared")
+}
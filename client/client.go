@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -19,6 +20,8 @@ import (
 	"github.com/NextChapterSoftware/chissl/share/ccrypto"
 	"github.com/NextChapterSoftware/chissl/share/cio"
 	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/inspect"
+	"github.com/NextChapterSoftware/chissl/share/pcap"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/NextChapterSoftware/chissl/share/tunnel"
 	"github.com/gorilla/websocket"
@@ -41,6 +44,7 @@ type Client struct {
 	stop      func()
 	eg        *errgroup.Group
 	tunnel    *tunnel.Tunnel
+	inspector *inspect.Inspector
 }
 
 // NewClient creates a new client instance
@@ -72,7 +76,8 @@ func NewClient(c *Config) (*Client, error) {
 		Logger: cio.NewLogger("client"),
 		config: c,
 		computed: settings.Config{
-			Version: chshare.BuildVersion,
+			OpenVersion: tunnel.OpenVersion,
+			Version:     chshare.BuildVersion,
 		},
 		server:    u.String(),
 		tlsConfig: nil,
@@ -113,6 +118,8 @@ func NewClient(c *Config) (*Client, error) {
 		client.tlsConfig = tc
 	}
 	//validate remotes
+	targets := map[string]*tunnel.Target{}
+	targetRemotes := map[string]*settings.Remote{}
 	for _, s := range c.Remotes {
 		r, err := settings.DecodeRemote(s)
 		if err != nil {
@@ -122,6 +129,24 @@ func NewClient(c *Config) (*Client, error) {
 		if r.Reverse {
 			hasReverse = true
 		}
+		if r.Socks {
+			hasSocks = true
+		}
+		if r.Reverse {
+			//the server only names the target it dials, so remotes
+			//sharing a target must agree on how it is dialed
+			if other, ok := targetRemotes[r.Remote()]; ok && !sameTarget(other, r) {
+				return nil, fmt.Errorf("Invalid remote '%s': target %s is used by '%s' with other options", s, r.Remote(), other.String())
+			}
+			targetRemotes[r.Remote()] = r
+			target, err := newTarget(r)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid remote '%s': %s", s, err)
+			}
+			if target != nil {
+				targets[r.Remote()] = target
+			}
+		}
 
 		//confirm non-reverse tunnel is available
 		if !r.Reverse && !r.CanListen() {
@@ -129,6 +154,11 @@ func NewClient(c *Config) (*Client, error) {
 		}
 		client.computed.Remotes = append(client.computed.Remotes, r)
 	}
+	//optional socks destination allowlist
+	socksAllow, err := settings.ParseCIDRs(c.SocksAllow)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid socks allowlist: %s", err)
+	}
 	//outbound proxy
 	if p := c.Proxy; p != "" {
 		client.proxyURL, err = url.Parse(p)
@@ -145,18 +175,98 @@ func NewClient(c *Config) (*Client, error) {
 		HostKeyCallback: client.verifyServer,
 		Timeout:         settings.EnvDuration("SSH_TIMEOUT", 30*time.Second),
 	}
+	//credentials hidden from logged, inspected and recorded traffic
+	redactor, err := cio.NewRedactor(c.RedactHeaders, c.RedactFields)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid redaction: %s", err)
+	}
+	//optional inspection and recording of reverse http traffic
+	var tap func(string, io.ReadWriteCloser) io.ReadWriteCloser
+	if c.Inspect != "" {
+		client.inspector = inspect.NewInspector(settings.EnvInt("INSPECT_MAX_EXCHANGES", 500))
+	}
+	var recorder *inspect.Recorder
+	if c.Record != "" {
+		if recorder, err = inspect.NewRecorder(c.Record, c.RecordFormat); err != nil {
+			return nil, fmt.Errorf("Failed to record: %s", err)
+		}
+		client.Infof("Recording HTTP traffic to %s", c.Record)
+	}
+	if client.inspector != nil || recorder != nil {
+		observe := func(ex *inspect.Exchange) {
+			if !c.RecordRaw {
+				inspect.Redact(redactor, ex)
+			}
+			if client.inspector != nil {
+				client.inspector.Add(ex)
+			}
+			if recorder != nil {
+				if err := recorder.Save(ex); err != nil {
+					client.Infof("Failed to record %s %s: %s", ex.Method, ex.URL, err)
+				}
+			}
+		}
+		tap = func(remote string, src io.ReadWriteCloser) io.ReadWriteCloser {
+			return inspect.Tap(src, remote, observe)
+		}
+	}
+	//optional capture of tunneled streams
+	var capture *pcap.Capture
+	if c.Pcap != "" {
+		if capture, err = pcap.New(c.Pcap, c.PcapSplit); err != nil {
+			return nil, fmt.Errorf("Failed to capture: %s", err)
+		}
+		client.Infof("Capturing tunneled streams to %s", c.Pcap)
+	}
 	//prepare client tunnel
 	client.tunnel = tunnel.New(tunnel.Config{
-		Logger:    client.Logger,
-		Inbound:   true, //client always accepts inbound
-		Outbound:  hasReverse,
-		Socks:     hasReverse && hasSocks,
-		KeepAlive: client.config.KeepAlive,
-		IsClient:  true,
+		Logger:     client.Logger,
+		Inbound:    true, //client always accepts inbound
+		Outbound:   hasReverse,
+		Socks:      hasReverse && hasSocks,
+		SocksAllow: socksAllow,
+		Targets:    targets,
+		Inspect:    tap,
+		Redact:     redactor,
+		Capture:    capture,
+		Session:    "session-" + time.Now().Format("20060102T150405"),
+		KeepAlive:  client.config.KeepAlive,
+		IsClient:   true,
 	})
 	return client, nil
 }
 
+// newTarget prepares the handling of connections to the
+// target of a reverse remote, nil when there's nothing to do
+func newTarget(r *settings.Remote) (*tunnel.Target, error) {
+	if r.CertFile == "" && r.Upstream == "" && r.ProxyProtocol == "" {
+		return nil, nil
+	}
+	t := &tunnel.Target{ProxyProtocol: r.ProxyProtocol}
+	//passthrough tls terminated by the client
+	if r.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading cert and key pair: %v", err)
+		}
+		t.TerminateTLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	//re-encrypted connections to the target
+	if r.Upstream != "" {
+		t.UpstreamTLS = &tls.Config{
+			ServerName:         r.RemoteHost,
+			InsecureSkipVerify: r.Upstream == settings.UpstreamTLSInsecure,
+		}
+	}
+	return t, nil
+}
+
+// sameTarget checks that two remotes dial their target alike
+func sameTarget(a, b *settings.Remote) bool {
+	return a.CertFile == b.CertFile && a.KeyFile == b.KeyFile &&
+		a.Upstream == b.Upstream && a.ProxyProtocol == b.ProxyProtocol
+}
+
 // Run starts client and blocks while connected
 func (c *Client) Run() error {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -214,6 +324,15 @@ func (c *Client) Start(ctx context.Context) error {
 		via = " via " + c.proxyURL.String()
 	}
 	c.Infof("Connecting to %s%s\n", c.server, via)
+	//serve the inspector
+	if c.inspector != nil {
+		h := cnet.NewHTTPServer()
+		if err := h.GoListenAndServeContext(ctx, c.config.Inspect, c.inspector); err != nil {
+			return err
+		}
+		c.Infof("Inspecting HTTP traffic on http://%s", c.config.Inspect)
+		eg.Go(h.Wait)
+	}
 	//connect to chisel server
 	eg.Go(func() error {
 		return c.connectionLoop(ctx)
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -112,3 +113,21 @@ func TestVerifyFingerprint(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConflictingTargets(t *testing.T) {
+	//one target, dialed alike
+	if _, err := NewClient(&Config{
+		Server:  "http://localhost:9000",
+		Remotes: []string{"8001->5432?proxy=v1", "8002->5432?proxy=v1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	//one target, dialed with and without a PROXY header
+	_, err := NewClient(&Config{
+		Server:  "http://localhost:9000",
+		Remotes: []string{"8001->5432?proxy=v1", "8002->5432"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "other options") {
+		t.Fatalf("expected the remotes to conflict, got %v", err)
+	}
+}
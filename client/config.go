@@ -22,10 +22,20 @@ type Config struct {
 	Server           string        `yaml:"server,omitempty"`
 	Proxy            string        `yaml:"proxy,omitempty"`
 	Remotes          []string      `yaml:"remotes,omitempty"`
+	SocksAllow       []string      `yaml:"socks-allow,omitempty"`
+	Inspect          string        `yaml:"inspect,omitempty"`
+	Record           string        `yaml:"record,omitempty"`
+	RecordFormat     string        `yaml:"record-format,omitempty"`
+	RecordRaw        bool          `yaml:"record-raw,omitempty"`
+	RedactHeaders    []string      `yaml:"redact-headers,omitempty"`
+	RedactFields     []string      `yaml:"redact-fields,omitempty"`
+	Pcap             string        `yaml:"pcap,omitempty"`
+	PcapSplit        string        `yaml:"pcap-split,omitempty"`
 	Headers          http.Header   `yaml:"headers,omitempty"`
 	TLS              TLSConfig     `yaml:"tls,omitempty"`
-	DialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
-	Verbose          bool `yaml:"verbose,omitempty"`
+	Verbose          bool          `yaml:"verbose,omitempty"`
+
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error) `yaml:"-"`
 }
 
 // TLSConfig for a Client
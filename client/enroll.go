@@ -0,0 +1,91 @@
+package chclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"gopkg.in/yaml.v3"
+)
+
+// Enroll redeems an invitation token with the server named in
+// it, returning the profile of the user it creates. The server
+// fingerprint it returns must match the one signed in the token.
+func Enroll(token string, tc TLSConfig) (*Config, error) {
+	inv, err := settings.ParseInvite(token)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: tc.SkipVerify, ServerName: tc.ServerName}
+	if tc.CA != "" {
+		b, err := os.ReadFile(tc.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load file: %s", tc.CA)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("Failed to decode PEM: %s", tc.CA)
+		}
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+	server := inv.Server
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	body, _ := json.Marshal(map[string]string{"token": token})
+	resp, err := client.Post(strings.TrimSuffix(server, "/")+"/invite/redeem", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	r := &settings.InviteRedemption{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	if inv.Fingerprint != "" && r.Fingerprint != inv.Fingerprint {
+		return nil, errors.New("server fingerprint does not match the invitation")
+	}
+	return &Config{
+		Server:      inv.Server,
+		Auth:        r.Username + ":" + r.Password,
+		Fingerprint: r.Fingerprint,
+		TLS:         tc,
+	}, nil
+}
+
+// WriteProfile writes the config as a profile yaml file,
+// to ~/.chissl/profile.yaml when profilePath is empty
+func (c *Config) WriteProfile(profilePath string) error {
+	filePath := parsePath(profilePath)
+	if fileExists(filePath) {
+		return fmt.Errorf("profile %s already exists", filePath)
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0600)
+}
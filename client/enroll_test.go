@@ -0,0 +1,78 @@
+package chclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+func TestEnroll(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	fingerprint := "aGVsbG8="
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		inv, err := settings.VerifyInvite(key, req.Token, time.Now())
+		if r.URL.Path != "/invite/redeem" || err != nil {
+			http.Error(w, "invalid", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(&settings.InviteRedemption{
+			Username:    inv.Username,
+			Password:    "generated1234",
+			Fingerprint: fingerprint,
+		})
+	}))
+	defer ts.Close()
+	sign := func(fp string) string {
+		token, err := settings.SignInvite(key, &settings.Invite{
+			Server:      ts.URL,
+			Fingerprint: fp,
+			Username:    "guest",
+			Addrs:       []string{".*"},
+			RedeemBy:    time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	config, err := Enroll(sign(fingerprint), TLSConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server != ts.URL || config.Auth != "guest:generated1234" || config.Fingerprint != fingerprint {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if _, err := Enroll(sign("another"), TLSConfig{}); err == nil || !strings.Contains(err.Error(), "fingerprint") {
+		t.Fatalf("expected a fingerprint mismatch, got %v", err)
+	}
+
+	profile := filepath.Join(t.TempDir(), "chissl", "profile.yaml")
+	if err := config.WriteProfile(profile); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.WriteProfile(profile); err == nil {
+		t.Fatalf("expected an existing profile to be kept")
+	}
+	loaded, err := NewClientConfig(profile, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Server != ts.URL || loaded.Auth != config.Auth || loaded.Fingerprint != fingerprint {
+		t.Fatalf("unexpected profile %+v", loaded)
+	}
+	if info, _ := os.Stat(profile); info.Mode().Perm() != 0600 {
+		t.Fatalf("expected a private profile, got %s", info.Mode())
+	}
+}
@@ -2,12 +2,16 @@ package chserver
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 
@@ -15,7 +19,9 @@ import (
 	"github.com/NextChapterSoftware/chissl/share/ccrypto"
 	"github.com/NextChapterSoftware/chissl/share/cio"
 	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/pcap"
 	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/NextChapterSoftware/chissl/share/tunnel"
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/requestlog"
 	"golang.org/x/crypto/ssh"
@@ -32,6 +38,35 @@ type Config struct {
 	KeepAlive time.Duration
 	TLS       TLSConfig
 	TlsConf   *tls.Config
+	//ProxyProtocol accepts PROXY protocol headers on the
+	//server's listener and on its reverse remote listeners
+	ProxyProtocol bool
+	//TrustedProxies lists the CIDRs of load balancers whose
+	//PROXY headers and X-Forwarded-For headers are believed
+	TrustedProxies []string
+	//Pcap is a directory in which tunneled
+	//TCP streams are written as pcapng files
+	Pcap string
+	//PcapSplit writes one file per remote
+	//(default) or per session
+	PcapSplit string
+	//AuthMaxFailures consecutive failed logins lock
+	//out the client's ip or the username (default 5)
+	AuthMaxFailures int
+	//AuthLockout is how long lockouts last (default 15m)
+	AuthLockout time.Duration
+	//AuthDelay is the delay after a first failed login,
+	//which doubles with each further failure (default 250ms)
+	AuthDelay time.Duration
+	//AuthStore is how AuthFile is kept: a JSON
+	//file (json, default) or a bolt database (bolt)
+	AuthStore string
+	//AuthHistory is how many previous versions of the users
+	//are kept (20 when zero, negative to keep none)
+	AuthHistory int
+	//SocketDir is the directory in which remotes may listen
+	//on or dial unix sockets on the server (disabled if unset)
+	SocketDir string
 }
 
 // Server respresent a chisel service
@@ -40,10 +75,17 @@ type Server struct {
 	config       *Config
 	fingerprint  string
 	httpServer   *cnet.HTTPServer
+	lockouts     *lockouts
+	otp          *secondFactor
+	inviteKey    []byte
 	reverseProxy *httputil.ReverseProxy
 	sessCount    int32
 	sessions     *settings.Users
+	shares       *tunnel.ShareIndex
+	passthrough  *tunnel.SNIRouter
+	capture      *pcap.Capture
 	sshConfig    *ssh.ServerConfig
+	trusted      *cnet.TrustedProxies
 	users        *settings.UserIndex
 }
 
@@ -60,17 +102,71 @@ func NewServer(c *Config) (*Server, error) {
 		httpServer: cnet.NewHTTPServer(),
 		Logger:     cio.NewLogger("server"),
 		sessions:   settings.NewUsers(),
+		shares:     tunnel.NewShareIndex(),
 	}
 	server.Info = true
+	cidrs, err := settings.ParseCIDRs(c.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid trusted proxies: %s", err)
+	}
+	if c.ProxyProtocol && len(cidrs) == 0 {
+		return nil, errors.New("The PROXY protocol requires --trusted-proxy")
+	}
+	server.trusted = &cnet.TrustedProxies{
+		CIDRs:         cidrs,
+		ProxyProtocol: c.ProxyProtocol,
+		Timeout:       settings.EnvDuration("PROXY_PROTOCOL_TIMEOUT", 10*time.Second),
+	}
+	if c.AuthMaxFailures == 0 {
+		c.AuthMaxFailures = 5
+	}
+	if c.AuthLockout == 0 {
+		c.AuthLockout = 15 * time.Minute
+	}
+	if c.AuthDelay == 0 {
+		c.AuthDelay = 250 * time.Millisecond
+	}
+	if c.AuthHistory == 0 {
+		c.AuthHistory = 20
+	}
+	if c.SocketDir != "" {
+		if c.SocketDir, err = filepath.Abs(c.SocketDir); err != nil {
+			return nil, fmt.Errorf("Invalid socket directory: %s", err)
+		}
+	}
+	server.lockouts = newLockouts(c.AuthMaxFailures, c.AuthLockout, c.AuthDelay,
+		settings.EnvDuration("AUTH_MAX_DELAY", 10*time.Second), settings.EnvInt("AUTH_MAX_DELAYED", 100))
+	server.otp = newSecondFactor(settings.EnvDuration("ADMIN_SESSION_TTL", 15*time.Minute))
+	server.passthrough = tunnel.NewSNIRouter(server.Logger, server.trusted)
+	if c.Pcap != "" {
+		if server.capture, err = pcap.New(c.Pcap, c.PcapSplit); err != nil {
+			return nil, fmt.Errorf("Failed to capture: %s", err)
+		}
+		server.Infof("Capturing tunneled streams to %s", c.Pcap)
+	}
 	server.users = settings.NewUserIndex(server.Logger)
 	if c.AuthFile != "" {
-		if err := server.users.LoadUsers(c.AuthFile); err != nil {
+		store, err := settings.OpenUserStore(c.AuthStore, c.AuthFile, c.AuthHistory)
+		if err != nil {
+			return nil, err
+		}
+		if err := server.users.LoadUsers(store); err != nil {
+			store.Close()
 			return nil, err
 		}
+		//without users, every client is let in
+		if server.users.Len() == 0 && c.Auth == "" {
+			if c.AuthStore == settings.StoreBolt {
+				server.users.Close()
+				return nil, errors.New("The user store is empty, set --auth to import users into it")
+			}
+			server.Infof("Warning: the authfile has no users, so authentication is disabled")
+		}
 	}
 	if c.Auth != "" {
 		u := &settings.User{Addrs: []*regexp.Regexp{settings.UserAllowAll}}
 		u.IsAdmin = true
+		u.AllowSocks = true
 		u.Name, u.Pass = settings.ParseAuth(c.Auth)
 		if u.Name != "" {
 			server.users.AddUser(u)
@@ -78,7 +174,6 @@ func NewServer(c *Config) (*Server, error) {
 	}
 
 	var pemBytes []byte
-	var err error
 	if c.KeyFile != "" {
 		var key []byte
 
@@ -113,6 +208,9 @@ func NewServer(c *Config) (*Server, error) {
 	}
 	//fingerprint this key
 	server.fingerprint = ccrypto.FingerprintKey(private.PublicKey())
+	//invitations are signed with a key derived from it
+	inviteKey := sha256.Sum256(append([]byte("chissl-invite:"), pemBytes...))
+	server.inviteKey = inviteKey[:]
 	//create ssh config
 	server.sshConfig = &ssh.ServerConfig{
 		ServerVersion:    "SSH-" + chshare.ProtocolVersion + "-server",
@@ -169,9 +267,13 @@ func (s *Server) Wait() error {
 	return s.httpServer.Wait()
 }
 
-// Close forcibly closes the http server
+// Close forcibly closes the http server, and the user store
 func (s *Server) Close() error {
-	return s.httpServer.Close()
+	err := s.httpServer.Close()
+	if uerr := s.users.Close(); err == nil {
+		err = uerr
+	}
+	return err
 }
 
 // GetFingerprint is used to access the server fingerprint
@@ -187,17 +289,57 @@ func (s *Server) authUser(c ssh.ConnMetadata, password []byte) (*ssh.Permissions
 	}
 	// check the user exists and has matching password
 	n := c.User()
+	ip := addrIP(c.RemoteAddr())
+	if s.lockouts.locked(ip) {
+		s.Debugf("Login refused for user: %s from locked out %s", n, c.RemoteAddr())
+		return nil, errors.New("Too many failed logins")
+	}
 	user, found := s.users.Get(n)
 	if !found || user.Pass != string(password) {
-		s.Debugf("Login failed for user: %s", n)
+		s.Debugf("Login failed for user: %s from %s", n, c.RemoteAddr())
+		s.failedLogin(ip, n)
 		return nil, errors.New("Invalid authentication for username: %s")
 	}
+	s.lockouts.succeed(ip, n)
+	if !user.Active(time.Now()) {
+		s.Debugf("Login refused for disabled or expired user: %s from %s", n, c.RemoteAddr())
+		return nil, errors.New("User disabled or expired")
+	}
 	// insert the user session map
 	// TODO this should probably have a lock on it given the map isn't thread-safe
-	s.sessions.Set(string(c.SessionID()), user)
+	s.sessions.Set(string(c.SessionID()), s.users.Resolve(user))
 	return nil, nil
 }
 
+// failedLogin records a failed login and
+// delays the response to slow down guessing
+func (s *Server) failedLogin(ip, user string) {
+	delay, ipLocked, userLocked := s.lockouts.fail(ip, user)
+	if userLocked {
+		s.Infof("Throttling logins of user: %s after repeated failed logins", user)
+	}
+	if ipLocked {
+		//later logins are refused without a delay
+		s.Infof("Locked out %s after repeated failed logins", ip)
+		return
+	}
+	if !s.lockouts.wait(delay) {
+		s.Debugf("Too many delayed logins, not delaying user: %s from %s", user, ip)
+	}
+}
+
+// addrIP returns the ip of addr, or addr itself
+// when it has none (e.g. on unix sockets)
+func addrIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
 // AddUser adds a new user into the server user index
 func (s *Server) AddUser(user, pass string, addrs ...string) error {
 	authorizedAddrs := []*regexp.Regexp{}
@@ -0,0 +1,256 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// OTP headers of admin REST requests
+const (
+	HeaderOTP     = "X-OTP"
+	HeaderSession = "X-Session-Token"
+)
+
+// secondFactor holds the TOTP state of admins: secrets
+// awaiting confirmation, the last step used by each admin
+// (so that codes can't be replayed) and session tokens
+type secondFactor struct {
+	mu       sync.Mutex
+	pending  map[string]string
+	used     map[string]int64
+	sessions map[string]adminSession
+	ttl      time.Duration
+}
+
+type adminSession struct {
+	user    string
+	expires time.Time
+}
+
+// TOTPSession is returned when exchanging a code for a session token
+type TOTPSession struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// TOTPEnrollment is returned when enrolling an admin
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func newSecondFactor(ttl time.Duration) *secondFactor {
+	return &secondFactor{
+		pending:  map[string]string{},
+		used:     map[string]int64{},
+		sessions: map[string]adminSession{},
+		ttl:      ttl,
+	}
+}
+
+// verify checks the code of an admin, which may only be used once
+func (f *secondFactor) verify(u *settings.User, code string) bool {
+	step, ok := settings.VerifyTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if step <= f.used[u.Name] {
+		return false
+	}
+	f.used[u.Name] = step
+	return true
+}
+
+// session checks the session token of an admin
+func (f *secondFactor) session(user, token string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[token]
+	if ok && time.Now().After(s.expires) {
+		delete(f.sessions, token)
+		return false
+	}
+	return ok && s.user == user
+}
+
+// newSession creates a session token for an admin
+func (f *secondFactor) newSession(user string) (*TOTPSession, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &TOTPSession{Token: hex.EncodeToString(b), Expires: time.Now().Add(f.ttl)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for token, s := range f.sessions {
+		if now.After(s.expires) {
+			delete(f.sessions, token)
+		}
+	}
+	f.sessions[s.Token] = adminSession{user: user, expires: s.Expires}
+	return s, nil
+}
+
+// reset forgets the pending secret and sessions of an admin
+func (f *secondFactor) reset(user string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.pending, user)
+	for token, s := range f.sessions {
+		if s.user == user {
+			delete(f.sessions, token)
+		}
+	}
+}
+
+// checkSecondFactor requires admins enrolled in TOTP to send a
+// valid code, or a session token obtained with one. It writes
+// the error response and returns false when neither is valid.
+func (s *Server) checkSecondFactor(w http.ResponseWriter, r *http.Request, u *settings.User, ip string) bool {
+	if u.TOTPSecret == "" {
+		return true
+	}
+	if token := r.Header.Get(HeaderSession); token != "" && s.otp.session(u.Name, token) {
+		return true
+	}
+	code := r.Header.Get(HeaderOTP)
+	if code == "" && r.Header.Get(HeaderSession) == "" {
+		http.Error(w, "One-time password required", http.StatusUnauthorized)
+		return false
+	}
+	if code == "" || !s.otp.verify(u, code) {
+		s.Debugf("Admin one-time password failed for user: %s from %s", u.Name, ip)
+		s.failedLogin(ip, u.Name)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// requestingUser returns the admin making the request
+func (s *Server) requestingUser(r *http.Request) (*settings.User, bool) {
+	username, _, _ := s.decodeBasicAuthHeader(r.Header)
+	return s.users.Get(username)
+}
+
+// handleEnroll generates a TOTP secret for the requesting
+// admin, which is enabled once confirmed with a code
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	u, found := s.requestingUser(r)
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	secret, err := settings.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.otp.mu.Lock()
+	s.otp.pending[u.Name] = secret
+	s.otp.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&TOTPEnrollment{
+		Secret: secret,
+		URI:    settings.TOTPURI("chiSSL", u.Name+"@"+r.Host, secret),
+	})
+}
+
+// handleConfirm enables the pending secret of the requesting
+// admin, given a {"code": "..."} generated with it
+func (s *Server) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	u, found := s.requestingUser(r)
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	s.otp.mu.Lock()
+	secret, ok := s.otp.pending[u.Name]
+	s.otp.mu.Unlock()
+	if !ok {
+		http.Error(w, "No pending enrollment", http.StatusBadRequest)
+		return
+	}
+	enrolled := *u
+	enrolled.TOTPSecret = secret
+	if !s.otp.verify(&enrolled, req.Code) {
+		http.Error(w, "Invalid one-time password", http.StatusBadRequest)
+		return
+	}
+	s.otp.reset(u.Name)
+	s.users.Set(u.Name, &enrolled)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.Infof("Enabled two-factor authentication for user: %s", u.Name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleSession exchanges the code of an
+// admin for a short-lived session token
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	u, found := s.requestingUser(r)
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if u.TOTPSecret == "" {
+		http.Error(w, "Two-factor authentication not enrolled", http.StatusBadRequest)
+		return
+	}
+	session, err := s.otp.newSession(u.Name)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// handleDisable2FA disables TOTP for the requesting admin on
+// /2fa, or for another admin on /2fa/{username}, so that
+// admins who lost their authenticator can be recovered
+func (s *Server) handleDisable2FA(w http.ResponseWriter, r *http.Request) {
+	requester, _ := s.requestingUser(r)
+	username := strings.Trim(strings.TrimPrefix(r.URL.Path, "/2fa"), "/")
+	if username == "" {
+		username = requester.Name
+	}
+	if username != requester.Name && !s.users.Resolve(requester).Can(settings.PermUsersGrant) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	u, found := s.users.Get(username)
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	disabled := *u
+	disabled.TOTPSecret = ""
+	s.otp.reset(u.Name)
+	s.users.Set(u.Name, &disabled)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.Infof("Disabled two-factor authentication for user: %s", u.Name)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -1,7 +1,9 @@
 package chserver
 
 import (
+	"fmt"
 	"net/http"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -45,30 +47,90 @@ func (s *Server) handleClientHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(chshare.BuildVersion))
 		return
 	case strings.HasPrefix(path, "/authfile"):
+		switch {
+		case r.Method == http.MethodPost && path == "/authfile":
+			s.basicAuthMiddleware(settings.PermUsersGrant, s.transaction(s.handleAuthfile))(w, r) // Protecting with Basic Auth
+			return
+		case r.Method == http.MethodGet && path == "/authfile/history":
+			s.basicAuthMiddleware(settings.PermUsersRead, s.handleAuthfileHistory)(w, r) // Protecting with Basic Auth
+			return
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/authfile/rollback/"):
+			s.basicAuthMiddleware(settings.PermUsersGrant, s.transaction(s.handleRollback))(w, r) // Protecting with Basic Auth
+			return
+		}
+	case strings.HasPrefix(path, "/invite"):
+		switch {
+		case r.Method == http.MethodPost && path == "/invite/redeem":
+			s.transaction(s.handleRedeem)(w, r) // Authenticated by the token itself
+			return
+		case r.Method == http.MethodPost && path == "/invite":
+			s.basicAuthMiddleware(settings.PermUsersWrite, s.handleInvite)(w, r) // Protecting with Basic Auth
+			return
+		}
+	case path == "/me/password":
 		switch r.Method {
-		case http.MethodPost:
-			s.basicAuthMiddleware(s.handleAuthfile)(w, r) // Protecting with Basic Auth
+		case http.MethodPut:
+			s.basicAuthMiddleware("", s.transaction(s.handleChangePassword))(w, r) // Protecting with Basic Auth
+			return
+		}
+	case strings.HasPrefix(path, "/2fa"):
+		switch {
+		case r.Method == http.MethodPost && path == "/2fa/enroll":
+			s.basicAuthMiddleware("", s.handleEnroll)(w, r) // Protecting with Basic Auth
+			return
+		case r.Method == http.MethodPost && path == "/2fa/confirm":
+			s.basicAuthMiddleware("", s.transaction(s.handleConfirm))(w, r) // Protecting with Basic Auth
+			return
+		case r.Method == http.MethodPost && path == "/2fa/session":
+			s.basicAuthMiddleware("", s.handleSession)(w, r) // Protecting with Basic Auth
+			return
+		case r.Method == http.MethodDelete:
+			s.basicAuthMiddleware("", s.transaction(s.handleDisable2FA))(w, r) // Protecting with Basic Auth
+			return
+		}
+	case strings.HasPrefix(path, "/lockouts"):
+		switch r.Method {
+		case http.MethodGet:
+			s.basicAuthMiddleware(settings.PermLockoutsRead, s.handleGetLockouts)(w, r) // Protecting with Basic Auth
+			return
+		case http.MethodDelete:
+			s.basicAuthMiddleware(settings.PermLockoutsWrite, s.handleDeleteLockouts)(w, r) // Protecting with Basic Auth
+			return
+		}
+	case strings.HasPrefix(path, "/groups"):
+		switch r.Method {
+		case http.MethodGet:
+			s.basicAuthMiddleware(settings.PermUsersRead, s.handleGetGroups)(w, r) // Protecting with Basic Auth
+			return
+		}
+	case strings.HasPrefix(path, "/group"):
+		switch r.Method {
+		case http.MethodPut:
+			s.basicAuthMiddleware(settings.PermUsersGrant, s.transaction(s.handleSetGroup))(w, r) // Protecting with Basic Auth
+			return
+		case http.MethodDelete:
+			s.basicAuthMiddleware(settings.PermUsersGrant, s.transaction(s.handleDeleteGroup))(w, r) // Protecting with Basic Auth
 			return
 		}
 	case strings.HasPrefix(path, "/users"):
 		switch r.Method {
 		case http.MethodGet:
-			s.basicAuthMiddleware(s.handleGetUsers)(w, r) // Protecting with Basic Auth
+			s.basicAuthMiddleware(settings.PermUsersRead, s.handleGetUsers)(w, r) // Protecting with Basic Auth
 			return
 		}
 	case strings.HasPrefix(path, "/user"):
 		switch r.Method {
 		case http.MethodGet:
-			s.basicAuthMiddleware(s.handleGetUser)(w, r) // Protecting with Basic Auth
+			s.basicAuthMiddleware(settings.PermUsersRead, s.handleGetUser)(w, r) // Protecting with Basic Auth
 			return
 		case http.MethodDelete:
-			s.basicAuthMiddleware(s.handleDeleteUser)(w, r) // Protecting with Basic Auth
+			s.basicAuthMiddleware(settings.PermUsersWrite, s.transaction(s.handleDeleteUser))(w, r) // Protecting with Basic Auth
 			return
 		case http.MethodPost:
-			s.basicAuthMiddleware(s.handleAddUser)(w, r) // Protecting with Basic Auth
+			s.basicAuthMiddleware(settings.PermUsersWrite, s.transaction(s.handleAddUser))(w, r) // Protecting with Basic Auth
 			return
 		case http.MethodPut:
-			s.basicAuthMiddleware(s.handleUpdateUser)(w, r) // Protecting with Basic Auth
+			s.basicAuthMiddleware(settings.PermUsersWrite, s.transaction(s.handleUpdateUser))(w, r) // Protecting with Basic Auth
 			return
 		}
 	}
@@ -86,9 +148,11 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		l.Debugf("Failed to upgrade (%s)", err)
 		return
 	}
-	conn := cnet.NewWebSocketConn(wsConn)
+	//report the real client address, as seen by trusted proxies
+	ip := s.trusted.ClientIP(req)
+	conn := cnet.WithRemoteIP(cnet.NewWebSocketConn(wsConn), ip)
 	// perform SSH handshake on net.Conn
-	l.Debugf("Handshaking with %s...", req.RemoteAddr)
+	l.Debugf("Handshaking with %s...", ip)
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshConfig)
 	if err != nil {
 		s.Debugf("Failed to handshake (%s)", err)
@@ -140,8 +204,31 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 			v, chshare.BuildVersion)
 	}
 
+	owner := ""
+	var filter *cnet.IPFilter
+	if user != nil {
+		owner = user.Name
+		if user.Allow != nil || user.Deny != nil {
+			allow, err := settings.ParseCIDRs(user.Allow)
+			if err != nil {
+				failed(s.Errorf("invalid allow list of user '%s': %s", user.Name, err))
+				return
+			}
+			deny, err := settings.ParseCIDRs(user.Deny)
+			if err != nil {
+				failed(s.Errorf("invalid deny list of user '%s': %s", user.Name, err))
+				return
+			}
+			filter = &cnet.IPFilter{Allow: allow, Deny: deny}
+		}
+	}
 	//validate remotes
 	for _, r := range c.Remotes {
+		//the client may have sent any field values
+		if err := r.Verify(); err != nil {
+			failed(s.Errorf("invalid remote '%s': %s", r.String(), err))
+			return
+		}
 		//if user is provided, ensure they have
 		//access to the desired remotes
 		if user != nil {
@@ -150,6 +237,29 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 				failed(s.Errorf("access to '%s' denied", addr))
 				return
 			}
+			//socks endpoints expose the client's network
+			//and require an explicit permission
+			if r.Socks && !user.AllowSocks {
+				failed(s.Errorf("access to '%s' denied, socks not permitted", addr))
+				return
+			}
+		}
+		//unix sockets on the server are confined to --socket-dir
+		if path := r.ServerSocket(); path != "" && !s.socketAllowed(path) {
+			failed(s.Errorf("Unix socket '%s' not permitted, see --socket-dir", path))
+			return
+		}
+		//forward remotes are dialed by the server on demand
+		if !r.Reverse {
+			continue
+		}
+		//shares are published without a listener
+		if r.Publishes() {
+			if !s.shares.Available(r.Share, owner) {
+				failed(s.Errorf("Share '%s' is already published", r.Share))
+				return
+			}
+			continue
 		}
 		//confirm reverse tunnels are allowed
 		if !s.config.Reverse {
@@ -157,6 +267,15 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 			failed(s.Errorf("Reverse port forwaring not enabled on server"))
 			return
 		}
+		//passthrough remotes may share a listener, provided
+		//their server names differ
+		if r.TLS == settings.TLSPassthrough {
+			if !s.passthrough.Available(r) {
+				failed(s.Errorf("Server cannot route %s", r.String()))
+				return
+			}
+			continue
+		}
 		//confirm reverse tunnel is available
 		if !r.CanListen() {
 			failed(s.Errorf("Server cannot listen on %s", r.String()))
@@ -165,14 +284,47 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	}
 	//successfuly validated config!
 	r.Reply(true, nil)
+	//the server only dials the validated forward remotes
+	forwards := map[string]bool{}
+	for _, r := range c.Remotes.Reversed(false) {
+		forwards[r.Remote()] = true
+	}
 	//tunnel per ssh connection
 	tunnel := tunnel.New(tunnel.Config{
-		Logger:    l,
-		Inbound:   s.config.Reverse,
-		Outbound:  true, //server always accepts outbound
-		KeepAlive: s.config.KeepAlive,
-		TlsConf:   s.config.TlsConf,
+		Logger:   l,
+		Inbound:  s.config.Reverse,
+		Outbound: len(forwards) > 0,
+		AllowOutbound: func(remote string) bool {
+			return forwards[remote]
+		},
+		Shares:      s.shares,
+		Passthrough: s.passthrough,
+		User:        owner,
+		Trusted:     s.trusted,
+		Filter:      filter,
+		OpenVersion: c.OpenVersion,
+		Capture:     s.capture,
+		Session:     fmt.Sprintf("session-%d-%s", id, owner),
+		KeepAlive:   s.config.KeepAlive,
+		TlsConf:     s.config.TlsConf,
 	})
+	//publish private services, until disconnected
+	serverInbound := settings.Remotes{}
+	for _, r := range c.Remotes.Reversed(true) {
+		if !r.Publishes() {
+			serverInbound = append(serverInbound, r)
+			continue
+		}
+		if err := s.shares.Publish(owner, tunnel, r); err != nil {
+			l.Infof("Failed to publish: %s", err)
+			continue
+		}
+		l.Infof("Published share '%s'", r.Share)
+	}
+	defer s.shares.Unpublish(tunnel)
+	if s.capture != nil {
+		defer s.capture.EndSession(tunnel.Session)
+	}
 	//bind
 	eg, ctx := errgroup.WithContext(req.Context())
 	eg.Go(func() error {
@@ -181,7 +333,6 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 	})
 	eg.Go(func() error {
 		//connected, setup reversed-remotes?
-		serverInbound := c.Remotes.Reversed(true)
 		if len(serverInbound) == 0 {
 			return nil
 		}
@@ -195,3 +346,13 @@ func (s *Server) handleWebsocket(w http.ResponseWriter, req *http.Request) {
 		l.Debugf("Closed connection")
 	}
 }
+
+// socketAllowed checks that a unix socket path lies
+// within the socket directory, which must be set
+func (s *Server) socketAllowed(path string) bool {
+	if s.config.SocketDir == "" || !filepath.IsAbs(path) {
+		return false
+	}
+	rel, err := filepath.Rel(s.config.SocketDir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
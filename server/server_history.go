@@ -0,0 +1,67 @@
+package chserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// handleAuthfileHistory lists the previous versions of the users
+func (s *Server) handleAuthfileHistory(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.users.History()
+	if err != nil {
+		s.Infof("Failed to list auth file history: %s", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", s.users.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// handleRollback restores a previous version of the users,
+// as a new revision, so that rollbacks can be undone too
+func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	rev, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/authfile/rollback/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	a, err := s.users.Version(rev)
+	if errors.Is(err, settings.ErrNoVersion) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.Infof("Failed to read auth file revision %d: %s", rev, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := a.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var requester *settings.User
+	if current, found := s.requestingUser(r); found {
+		for _, u := range a.Users {
+			if u.Name == current.Name {
+				requester = u
+			}
+		}
+	}
+	if !keepsGrant(a, requester) {
+		http.Error(w, "revision must include the current requesting user with admin permission", http.StatusBadRequest)
+		return
+	}
+	s.users.ResetGroups(a.Groups)
+	s.users.Reset(a.Users)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.Infof("Users rolled back to revision %d", rev)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,143 @@
+package chserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// handleInvite signs the posted invite, returning a
+// {"token": "..."} which creates its user when redeemed
+func (s *Server) handleInvite(w http.ResponseWriter, r *http.Request) {
+	inv := &settings.Invite{}
+	if err := json.NewDecoder(r.Body).Decode(inv); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if inv.Server == "" {
+		http.Error(w, "Invitation must name the server", http.StatusBadRequest)
+		return
+	}
+	if inv.Username == "" {
+		inv.Username = "guest" + randomHex(4)
+	}
+	if _, found := s.users.Get(inv.Username); found {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
+	}
+	if !time.Now().Before(inv.RedeemBy) {
+		http.Error(w, "Invitation must expire in the future", http.StatusBadRequest)
+		return
+	}
+	//validate the user it will create
+	u, err := inviteUser(inv, "placeholder")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.canManage(w, r, u) {
+		return
+	}
+	inv.Fingerprint = s.fingerprint
+	inv.Nonce = randomHex(16)
+	token, err := settings.SignInvite(s.inviteKey, inv)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.Infof("Invited user: %s until %s", inv.Username, inv.RedeemBy.Format(time.RFC3339))
+	if s.config.KeyFile == "" && s.config.KeySeed == "" {
+		//invitations are signed with a key derived from it
+		s.Infof("Warning: the server key is generated on each start, so invitations won't survive a restart (set --keyfile or --key)")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// handleRedeem creates the user of an invitation token posted
+// as {"token": "..."}, returning its generated credentials
+func (s *Server) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	if s.config.AuthFile == "" {
+		http.Error(w, "No auth file configured on server", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	inv, err := settings.VerifyInvite(s.inviteKey, req.Token, time.Now())
+	if err != nil {
+		s.Debugf("Invitation refused from %s: %s", s.trusted.ClientIP(r), err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	u, err := inviteUser(inv, randomHex(16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//tokens signed before nonces were added can't be tracked
+	if inv.Nonce == "" {
+		http.Error(w, "Invitation must be reissued", http.StatusForbidden)
+		return
+	}
+	//tokens are single use, even once their user is deleted
+	redeemed, err := s.users.Redeemed(inv.Nonce)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if _, found := s.users.Get(u.Name); found || redeemed {
+		http.Error(w, "Invitation already redeemed", http.StatusConflict)
+		return
+	}
+	s.users.Set(u.Name, u)
+	s.users.Redeem(inv.Nonce, inv.RedeemBy)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.Infof("Invitation redeemed by user: %s from %s", u.Name, s.trusted.ClientIP(r))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&settings.InviteRedemption{
+		Username:    u.Name,
+		Password:    u.Pass,
+		Fingerprint: s.fingerprint,
+		ExpiresAt:   u.ExpiresAt,
+	})
+}
+
+// inviteUser creates the user described by an invite
+func inviteUser(inv *settings.Invite, pass string) (*settings.User, error) {
+	u := &settings.User{
+		Name:       inv.Username,
+		Pass:       pass,
+		AllowSocks: inv.AllowSocks,
+		ExpiresAt:  inv.ExpiresAt,
+	}
+	for _, a := range inv.Addrs {
+		re, err := regexp.Compile(a)
+		if err != nil {
+			return nil, err
+		}
+		u.Addrs = append(u.Addrs, re)
+	}
+	if err := u.ValidateUser(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -47,6 +47,8 @@ func (s *Server) listener(host, port string) (net.Listener, error) {
 	if err != nil {
 		return nil, err
 	}
+	//optionally accept proxy protocol headers from load balancers
+	l = s.trusted.Listener(l)
 	//optionally wrap in tls
 	proto := "http"
 	if tlsConf != nil {
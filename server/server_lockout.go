@@ -0,0 +1,154 @@
+package chserver
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// lockouts tracks failed logins per ip and per username. Each
+// failure delays the response exponentially, and maxFailures
+// consecutive failures lock the ip out. Usernames are only
+// throttled, so that anyone can't lock a known account out by
+// guessing its password: the correct one is still accepted
+// from an ip which isn't locked out
+type lockouts struct {
+	mu          sync.Mutex
+	entries     map[string]*settings.LockoutEntry
+	maxFailures int
+	lockout     time.Duration
+	delay       time.Duration
+	maxDelay    time.Duration
+	//delayed holds a slot for each failed login being delayed
+	delayed chan struct{}
+}
+
+func newLockouts(maxFailures int, lockout, delay, maxDelay time.Duration, maxDelayed int) *lockouts {
+	return &lockouts{
+		entries:     map[string]*settings.LockoutEntry{},
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		delay:       delay,
+		maxDelay:    maxDelay,
+		delayed:     make(chan struct{}, maxDelayed),
+	}
+}
+
+// locked checks whether the ip is locked out
+func (l *lockouts) locked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[settings.LockoutIP+":"+ip]
+	return ok && time.Now().Before(e.Until)
+}
+
+// fail records a failed login, returning the time to wait
+// before responding, and which of the ip and username it
+// locked out. Failures of a throttled username wait the
+// longest delay
+func (l *lockouts) fail(ip, user string) (delay time.Duration, ipLocked, userLocked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+	for _, e := range []*settings.LockoutEntry{l.entry(settings.LockoutIP, ip), l.entry(settings.LockoutUser, user)} {
+		e.Failures++
+		e.Last = now
+		if l.maxFailures > 0 && e.Failures >= l.maxFailures && !now.Before(e.Until) {
+			e.Until = now.Add(l.lockout)
+			if e.Kind == settings.LockoutIP {
+				ipLocked = true
+			} else {
+				userLocked = true
+			}
+		}
+		d := l.delay << (e.Failures - 1)
+		if d > l.maxDelay || d <= 0 || now.Before(e.Until) {
+			d = l.maxDelay
+		}
+		if d > delay {
+			delay = d
+		}
+	}
+	return delay, ipLocked, userLocked
+}
+
+// wait sleeps for delay, unless too many failed logins are
+// already waiting, returning whether it did. Failures are
+// counted either way, so skipping the delay doesn't lift
+// the limit of each ip
+func (l *lockouts) wait(delay time.Duration) bool {
+	select {
+	case l.delayed <- struct{}{}:
+	default:
+		return false
+	}
+	time.Sleep(delay)
+	<-l.delayed
+	return true
+}
+
+// succeed forgets the failures of the ip and username
+func (l *lockouts) succeed(ip, user string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, settings.LockoutIP+":"+ip)
+	delete(l.entries, settings.LockoutUser+":"+user)
+}
+
+func (l *lockouts) entry(kind, key string) *settings.LockoutEntry {
+	e, ok := l.entries[kind+":"+key]
+	if !ok {
+		e = &settings.LockoutEntry{Kind: kind, Key: key}
+		l.entries[kind+":"+key] = e
+	}
+	return e
+}
+
+// prune forgets failures which are older than the lockout
+// duration, and lockouts which have expired
+func (l *lockouts) prune(now time.Time) {
+	for k, e := range l.entries {
+		if now.Sub(e.Last) > l.lockout && !now.Before(e.Until) {
+			delete(l.entries, k)
+		}
+	}
+}
+
+// list returns the tracked entries, locked entries first
+func (l *lockouts) list() []*settings.LockoutEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.prune(now)
+	list := []*settings.LockoutEntry{}
+	for _, e := range l.entries {
+		c := *e
+		if !now.Before(c.Until) {
+			c.Until = time.Time{}
+		}
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Until.IsZero() != list[j].Until.IsZero() {
+			return !list[i].Until.IsZero()
+		}
+		return list[i].Kind+list[i].Key < list[j].Kind+list[j].Key
+	})
+	return list
+}
+
+// clear forgets the given entry, or all entries when kind is empty
+func (l *lockouts) clear(kind, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if kind == "" {
+		l.entries = map[string]*settings.LockoutEntry{}
+		return true
+	}
+	_, ok := l.entries[kind+":"+key]
+	delete(l.entries, kind+":"+key)
+	return ok
+}
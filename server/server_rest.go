@@ -3,11 +3,15 @@ package chserver
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/NextChapterSoftware/chissl/share/settings"
+	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type UpdateUserRequest struct {
@@ -43,8 +47,9 @@ func (s *Server) decodeBasicAuthHeader(headers http.Header) (username, password
 	return credentials[0], credentials[1], true
 }
 
-// BasicAuthMiddleware validates the username and password
-func (s *Server) basicAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// BasicAuthMiddleware validates the username and password, and
+// that the user holds perm (any active user when perm is empty)
+func (s *Server) basicAuthMiddleware(perm string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if strings.TrimSpace(s.config.AuthFile) == "" {
 			http.Error(w, "No auth file configured on server", http.StatusUnauthorized)
@@ -56,19 +61,94 @@ func (s *Server) basicAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		ip := s.trusted.ClientIP(r)
+		if s.lockouts.locked(ip) {
+			s.Debugf("Admin login refused for user: %s from locked out %s", username, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.config.AuthLockout.Seconds())))
+			http.Error(w, "Too many failed logins", http.StatusTooManyRequests)
+			return
+		}
 		u, found := s.users.Get(username)
 
 		// Validate the credentials (Replace with your validation logic)
-		if !found || username != u.Name || password != u.Pass || !u.IsAdmin {
+		if !found || username != u.Name || password != u.Pass {
+			s.Debugf("Admin login failed for user: %s from %s", username, ip)
+			s.failedLogin(ip, username)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !u.Active(time.Now()) {
+			s.lockouts.succeed(ip, username)
+			s.Debugf("Admin login failed for user: %s from %s", username, ip)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if !s.checkSecondFactor(w, r, u, ip) {
+			return
+		}
+		s.lockouts.succeed(ip, username)
+		if perm != "" && !s.users.Resolve(u).Can(perm) {
+			s.Debugf("Permission %s denied for user: %s from %s", perm, username, ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 
 		// Proceed with the next handler
 		next.ServeHTTP(w, r)
 	}
 }
 
+// transaction runs next holding the users, so concurrent changes
+// can't be lost, and refuses changes based on a stale revision
+// when an If-Match header is sent. Successful responses carry
+// the new revision in their ETag header.
+func (s *Server) transaction(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := s.users.Transaction(r.Header.Get("If-Match"), func() error {
+			next.ServeHTTP(&etagWriter{ResponseWriter: w, users: s.users}, r)
+			return nil
+		})
+		if errors.Is(err, settings.ErrConflict) {
+			w.Header().Set("ETag", s.users.ETag())
+			http.Error(w, "Auth file was modified, reload and retry", http.StatusConflict)
+		} else if err != nil {
+			s.Infof("Failed to load auth file: %s", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// etagWriter sets the ETag header of successful responses
+type etagWriter struct {
+	http.ResponseWriter
+	users *settings.UserIndex
+}
+
+func (e *etagWriter) WriteHeader(code int) {
+	if code < http.StatusBadRequest {
+		e.Header().Set("ETag", e.users.ETag())
+	}
+	e.ResponseWriter.WriteHeader(code)
+}
+
+// canManage checks that the requesting user may manage target,
+// which must only belong to existing groups. It writes the
+// error response and returns false otherwise.
+func (s *Server) canManage(w http.ResponseWriter, r *http.Request, target *settings.User) bool {
+	for _, g := range target.Groups {
+		if _, found := s.users.GetGroup(g); !found {
+			http.Error(w, fmt.Sprintf("Unknown group '%s'", g), http.StatusBadRequest)
+			return false
+		}
+	}
+	requester, found := s.requestingUser(r)
+	if !found || !s.users.Resolve(requester).CanManage(s.users.Resolve(target)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 func getUsernameFromPath(path string) (string, error) {
 	// Define the expected URL pattern
 	pattern := `^/user/([^/]+)$`
@@ -83,6 +163,12 @@ func getUsernameFromPath(path string) (string, error) {
 }
 
 func (s *Server) handleGetUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", s.users.ETag())
+	if !s.mayReadCredentials(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.users.WithoutCredentials())
+		return
+	}
 	data, err := s.users.ToJSON()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -91,6 +177,14 @@ func (s *Server) handleGetUsers(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(data))
 }
 
+// mayReadCredentials checks whether the requesting user may
+// see passwords and second factors, which would otherwise
+// let it log in as users holding more permissions
+func (s *Server) mayReadCredentials(r *http.Request) bool {
+	requester, found := s.requestingUser(r)
+	return found && s.users.Resolve(requester).Can(settings.PermUsersGrant)
+}
+
 func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
 	username, err := getUsernameFromPath(r.URL.Path)
 	if err != nil {
@@ -98,12 +192,16 @@ func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("ETag", s.users.ETag())
 	u, found := s.users.Get(username)
 	if !found {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
+	if !s.mayReadCredentials(r) {
+		u = u.WithoutCredentials()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	responseJson, err := u.ToJSON()
 	if err != nil {
@@ -133,6 +231,10 @@ func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.canManage(w, r, &newUser) {
+		return
+	}
+
 	// Add the user to the server's user collection
 	s.users.Set(newUser.Name, &newUser)
 	err := s.users.WriteUsers()
@@ -160,14 +262,19 @@ func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get current user making this request
-	requestingUser, _, _ := s.decodeBasicAuthHeader(r.Header)
+	requestingUser, _ := s.requestingUser(r)
 
 	// Admins cannot revoke admin permission from themselves
-	if !targetUser.IsAdmin && targetUser.Name == requestingUser {
+	if targetUser.Name == requestingUser.Name && s.users.Resolve(requestingUser).Can(settings.PermUsersGrant) &&
+		!s.users.Resolve(&targetUser).Can(settings.PermUsersGrant) {
 		http.Error(w, "Cannot revoke admin from yourself", http.StatusBadRequest)
 		return
 	}
 
+	if !s.canManage(w, r, targetUserFromLookup) || !s.canManage(w, r, &targetUser) {
+		return
+	}
+
 	if targetUser.Pass == "" {
 		targetUser.Pass = targetUserFromLookup.Pass
 	}
@@ -176,6 +283,15 @@ func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		targetUser.Addrs = targetUserFromLookup.Addrs
 	}
 
+	// Second factors are only managed through /2fa
+	targetUser.TOTPSecret = targetUserFromLookup.TOTPSecret
+
+	// Validate the user input
+	if err := targetUser.ValidateUser(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	s.users.Set(targetUser.Name, &targetUser)
 	err := s.users.WriteUsers()
 	if err != nil {
@@ -206,6 +322,10 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.canManage(w, r, u) {
+		return
+	}
+
 	s.users.Del(u.Name)
 	err = s.users.WriteUsers()
 	if err != nil {
@@ -217,38 +337,51 @@ func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleAuthfile(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body to create a User object
-	var users []*settings.User
-	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
+	// Parse the request body, a list of users or an object with groups
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	a, err := settings.DecodeAuthFile(b)
+	if err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
+	users := a.Users
 
 	if len(users) == 0 {
 		http.Error(w, "No users found in file", http.StatusBadRequest)
+		return
 	}
 
 	// Get current user making this request
 	requestingUser, _, _ := s.decodeBasicAuthHeader(r.Header)
 	u, _ := s.users.Get(requestingUser)
 
-	requestingUserFromPayload := &settings.User{}
+	var requestingUserFromPayload *settings.User
 	for _, user := range users {
 		err := user.ValidateUser()
 		if err != nil {
 			http.Error(w, fmt.Sprintf("invalid user setting for %s: %v", user.Name, err), http.StatusBadRequest)
+			return
 		}
 		if user.Name == u.Name {
 			requestingUserFromPayload = user
 		}
 	}
-	if requestingUserFromPayload == nil || !requestingUserFromPayload.IsAdmin {
+	if err := a.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !keepsGrant(a, requestingUserFromPayload) {
 		http.Error(w, "file must include the current requesting user with admin permission", http.StatusBadRequest)
 		return
 	}
 
+	s.users.ResetGroups(a.Groups)
 	s.users.Reset(users)
-	err := s.users.WriteUsers()
+	err = s.users.WriteUsers()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -256,3 +389,129 @@ func (s *Server) handleAuthfile(w http.ResponseWriter, r *http.Request) {
 	// Implement user update logic here
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// keepsGrant checks that the requesting user, as found in a,
+// keeps its permission to grant
+func keepsGrant(a *settings.AuthFile, requester *settings.User) bool {
+	payload := settings.NewUsers()
+	payload.ResetGroups(a.Groups)
+	return requester != nil && payload.Resolve(requester).Can(settings.PermUsersGrant)
+}
+
+func (s *Server) handleGetLockouts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.lockouts.list())
+}
+
+// handleDeleteLockouts clears all lockouts on /lockouts,
+// or a single one on /lockouts/{ip|user}/{value}
+func (s *Server) handleDeleteLockouts(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/lockouts"), "/")
+	if rest == "" {
+		s.lockouts.clear("", "")
+		s.Infof("Cleared all lockouts")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	kind, key, ok := strings.Cut(rest, "/")
+	if !ok || key == "" || (kind != settings.LockoutIP && kind != settings.LockoutUser) {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if !s.lockouts.clear(kind, key) {
+		http.Error(w, "Lockout not found", http.StatusNotFound)
+		return
+	}
+	s.Infof("Cleared lockout of %s %s", kind, key)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", s.users.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.users.Groups())
+}
+
+// handleSetGroup adds or replaces a group
+func (s *Server) handleSetGroup(w http.ResponseWriter, r *http.Request) {
+	var g settings.Group
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := g.ValidateGroup(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.users.SetGroup(&g)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleDeleteGroup deletes a group on /group/{name}, once it has no members
+func (s *Server) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/group/")
+	if _, found := s.users.GetGroup(name); !found {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if members := s.users.Members(name); len(members) > 0 {
+		http.Error(w, "Group still has members: "+strings.Join(members, ", "), http.StatusConflict)
+		return
+	}
+	s.users.DelGroup(name)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// PasswordChangeRequest is the body of PUT /me/password
+type PasswordChangeRequest struct {
+	Current string `json:"current_password"`
+	New     string `json:"new_password"`
+}
+
+// handleChangePassword lets any user change its own password
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req PasswordChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	u, found := s.requestingUser(r)
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if req.Current != u.Pass {
+		ip := s.trusted.ClientIP(r)
+		s.Debugf("Password change failed for user: %s from %s", u.Name, ip)
+		s.failedLogin(ip, u.Name)
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if req.New == u.Pass {
+		http.Error(w, "New password must differ from the current one", http.StatusBadRequest)
+		return
+	}
+	changed := *u
+	changed.Pass = req.New
+	if err := changed.ValidateUser(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.users.Set(u.Name, &changed)
+	if err := s.users.WriteUsers(); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	//sessions were obtained with the old password
+	s.otp.reset(u.Name)
+	s.Infof("Password changed by user: %s", u.Name)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -8,7 +8,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -80,7 +82,12 @@ func createTempAuthFile(t *testing.T) string {
 				 {"username":"foo","password":"bar12345","addresses":["^9001","^9002","^9003"],"is_admin":false},
 				 {"username":"ping","password":"pong1234","addresses":["^80[0-9]{2}"],"is_admin":false}
 				]`
-	tmpFile, err := os.CreateTemp("", "auth-*.json")
+	return writeAuthFile(t, authFileContent)
+}
+
+func writeAuthFile(t *testing.T, authFileContent string) string {
+	t.Helper()
+	tmpFile, err := os.CreateTemp(t.TempDir(), "auth-*.json")
 	if err != nil {
 		t.Fatalf("Failed to create temp file: %v", err)
 	}
@@ -392,6 +399,18 @@ func TestUpdateUserWithAuth(t *testing.T) {
 	if len(updatedUser.Addrs) != 1 {
 		t.Fatalf("failed to update addresses user")
 	}
+
+	// Invalid filters would let every peer through - Must fail
+	result, _ = httpRequestWithBodyWithBasicAuth(
+		http.MethodPut,
+		"http://127.0.0.1:"+tl.GetServerPort()+"/user",
+		`{"username":"foo","addresses":["^9001"],"deny":["not-an-ip"]}`,
+		"root",
+		"toor1234",
+	)
+	if !strings.HasPrefix(result, "invalid deny list") {
+		t.Fatalf("update invalid user - expected an invalid deny list but got '%s'", result)
+	}
 }
 
 func TestDeleteUserWithAuth(t *testing.T) {
@@ -625,3 +644,540 @@ func TestAddAuthfile(t *testing.T) {
 	}
 
 }
+
+func TestLockouts(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	defer os.Remove(authFilePath)
+	tl := &testLayout{server: &Config{
+		AuthFile:        authFilePath,
+		AuthMaxFailures: 2,
+		AuthDelay:       time.Millisecond,
+	}}
+	server, teardown := tl.setup(t)
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+
+	// Repeated wrong passwords - Must lock out
+	for i := 0; i < 2; i++ {
+		result, err := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/users", "ping", "WrongPassword")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "Unauthorized\n" {
+			t.Fatalf("invalid password - expected 'Unauthorized' but got '%s'", result)
+		}
+	}
+
+	// Correct password from a locked out ip - Must fail
+	result, err := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/users", "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Too many failed logins\n" {
+		t.Fatalf("locked out ip - expected 'Too many failed logins' but got '%s'", result)
+	}
+
+	// Clearing the ip lockout - Must let the admin in
+	server.lockouts.clear(settings.LockoutIP, "127.0.0.1")
+	result, err = httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/lockouts", "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []*settings.LockoutEntry{}
+	if err := json.Unmarshal([]byte(result), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "ping" || entries[0].Failures != 2 || entries[0].Until.IsZero() {
+		t.Fatalf("expected a single lockout of user ping but got '%s'", result)
+	}
+
+	// Clearing the user lockout through the API
+	result, err = httpRequestNoBodyWithBasicAuth(http.MethodDelete, base+"/lockouts/user/ping", "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.lockouts.list()) != 0 {
+		t.Fatalf("expected user ping to be unlocked but got '%s'", result)
+	}
+	result, err = httpRequestNoBodyWithBasicAuth(http.MethodDelete, base+"/lockouts/user/ping", "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Lockout not found\n" {
+		t.Fatalf("cleared lockout - expected 'Lockout not found' but got '%s'", result)
+	}
+
+	// Correct password of a throttled user from another ip - Must succeed
+	for i := 0; i < 2; i++ {
+		server.lockouts.fail("192.0.2.1", "ping")
+	}
+	if server.lockouts.locked("127.0.0.1") {
+		t.Fatalf("expected only 192.0.2.1 to be locked out")
+	}
+	result, err = httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/user/ping", "ping", "pong1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Forbidden\n" {
+		t.Fatalf("throttled user - expected the login to succeed, and be forbidden, but got '%s'", result)
+	}
+
+	// Beyond the delayed failures limit - Must answer at once
+	l := newLockouts(2, time.Minute, time.Hour, time.Hour, 1)
+	l.delayed <- struct{}{}
+	if l.wait(time.Hour) {
+		t.Fatalf("expected the failure not to be delayed")
+	}
+}
+
+func httpRequestWithHeaders(method, url, body string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth("root", "toor1234")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestTwoFactor(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile:  authFilePath,
+		AuthDelay: time.Millisecond,
+	})
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+
+	// Enroll and confirm with a code
+	result, err := httpRequestWithHeaders(http.MethodPost, base+"/2fa/enroll", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment := TOTPEnrollment{}
+	if err := json.Unmarshal([]byte(result), &enrollment); err != nil {
+		t.Fatalf("expected an enrollment but got '%s'", result)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/chiSSL:root@127.0.0.1") {
+		t.Fatalf("unexpected otpauth uri '%s'", enrollment.URI)
+	}
+	step := settings.TOTPCounter(time.Now())
+	code, _ := settings.TOTPCode(enrollment.Secret, step)
+	if result, err = httpRequestWithHeaders(http.MethodPost, base+"/2fa/confirm", `{"code":"`+code+`"}`, nil); err != nil || result != "" {
+		t.Fatalf("expected confirmation but got '%s' %v", result, err)
+	}
+	if b, _ := os.ReadFile(authFilePath); !strings.Contains(string(b), enrollment.Secret) {
+		t.Fatalf("expected the secret to be written to the authfile")
+	}
+
+	// Password only - Must fail
+	if result, _ = httpRequestWithHeaders(http.MethodGet, base+"/users", "", nil); result != "One-time password required\n" {
+		t.Fatalf("missing code - expected 'One-time password required' but got '%s'", result)
+	}
+	// Code already used - Must fail
+	if result, _ = httpRequestWithHeaders(http.MethodGet, base+"/users", "", map[string]string{HeaderOTP: code}); result != "Unauthorized\n" {
+		t.Fatalf("replayed code - expected 'Unauthorized' but got '%s'", result)
+	}
+
+	// Exchange a fresh code for a session token
+	code, _ = settings.TOTPCode(enrollment.Secret, step+1)
+	result, _ = httpRequestWithHeaders(http.MethodPost, base+"/2fa/session", "", map[string]string{HeaderOTP: code})
+	session := TOTPSession{}
+	if err := json.Unmarshal([]byte(result), &session); err != nil || session.Token == "" {
+		t.Fatalf("expected a session but got '%s'", result)
+	}
+	result, _ = httpRequestWithHeaders(http.MethodGet, base+"/user/foo", "", map[string]string{HeaderSession: session.Token})
+	if !strings.HasPrefix(result, `{"username":"foo"`) {
+		t.Fatalf("valid session - expected user info json but got '%s'", result)
+	}
+	if result, _ = httpRequestWithHeaders(http.MethodGet, base+"/users", "", map[string]string{HeaderSession: "invalid"}); result != "Unauthorized\n" {
+		t.Fatalf("invalid session - expected 'Unauthorized' but got '%s'", result)
+	}
+
+	// Disable with the session
+	if result, _ = httpRequestWithHeaders(http.MethodDelete, base+"/2fa", "", map[string]string{HeaderSession: session.Token}); result != "" {
+		t.Fatalf("expected 2fa to be disabled but got '%s'", result)
+	}
+	if result, _ = httpRequestWithHeaders(http.MethodGet, base+"/user/foo", "", nil); !strings.HasPrefix(result, `{"username":"foo"`) {
+		t.Fatalf("disabled 2fa - expected user info json but got '%s'", result)
+	}
+}
+
+func TestInvite(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile: authFilePath,
+	})
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+
+	// Create an invitation
+	expires := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second)
+	invite, _ := json.Marshal(&settings.Invite{
+		Server:    base,
+		Username:  "guest",
+		Addrs:     []string{"^9001$"},
+		ExpiresAt: &expires,
+		RedeemBy:  time.Now().Add(time.Hour),
+	})
+	result, err := httpRequestWithBodyWithBasicAuth(http.MethodPost, base+"/invite", string(invite), "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal([]byte(result), &resp); err != nil || resp.Token == "" {
+		t.Fatalf("expected an invitation token but got '%s'", result)
+	}
+
+	// Redeem it without credentials
+	redeem := func(token string) (*http.Response, string) {
+		r, err := http.Post(base+"/invite/redeem", "application/json", strings.NewReader(`{"token":"`+token+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Body.Close()
+		b, _ := io.ReadAll(r.Body)
+		return r, string(b)
+	}
+	r, result := redeem(resp.Token)
+	redemption := settings.InviteRedemption{}
+	if err := json.Unmarshal([]byte(result), &redemption); err != nil || r.StatusCode != http.StatusOK {
+		t.Fatalf("expected credentials but got '%s'", result)
+	}
+	if redemption.Username != "guest" || len(redemption.Password) < 8 || redemption.Fingerprint == "" {
+		t.Fatalf("unexpected redemption '%s'", result)
+	}
+	result, _ = httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/user/guest", "root", "toor1234")
+	u := settings.User{}
+	if err := json.Unmarshal([]byte(result), &u); err != nil || u.ExpiresAt == nil || !u.ExpiresAt.Equal(expires) {
+		t.Fatalf("expected the invited user to expire but got '%s'", result)
+	}
+
+	// Tokens are single use, and can't be tampered with
+	if r, result = redeem(resp.Token); r.StatusCode != http.StatusConflict {
+		t.Fatalf("redeemed twice - expected conflict but got '%s'", result)
+	}
+	if r, result = redeem(resp.Token + "x"); r.StatusCode != http.StatusForbidden {
+		t.Fatalf("tampered token - expected forbidden but got '%s'", result)
+	}
+
+	// Deleting the user doesn't make the token redeemable again
+	if result, _ = httpRequestNoBodyWithBasicAuth(http.MethodDelete, base+"/user/guest", "root", "toor1234"); result != "" {
+		t.Fatalf("expected the invited user to be deleted but got '%s'", result)
+	}
+	if r, result = redeem(resp.Token); r.StatusCode != http.StatusConflict {
+		t.Fatalf("redeemed after deletion - expected conflict but got '%s'", result)
+	}
+}
+
+func TestDisabledAdmin(t *testing.T) {
+	authFilePath := writeAuthFile(t, `[
+		{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true,"disabled":true},
+		{"username":"ping","password":"pong1234","addresses":[".*"],"is_admin":true,"expires_at":"2001-01-01T00:00:00Z"}
+	]`)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile: authFilePath,
+	})
+	defer teardown()
+
+	for _, user := range []string{"root", "ping"} {
+		pass := map[string]string{"root": "toor1234", "ping": "pong1234"}[user]
+		result, err := httpRequestNoBodyWithBasicAuth(http.MethodGet, "http://127.0.0.1:"+tl.GetServerPort()+"/users", user, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != "Unauthorized\n" {
+			t.Fatalf("inactive admin %s - expected 'Unauthorized' but got '%s'", user, result)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	authFilePath := writeAuthFile(t, `{
+		"groups": [{"name":"helpdesk","roles":["user-manager"]}],
+		"users": [
+			{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true},
+			{"username":"help","password":"help1234","addresses":[".*"],"groups":["helpdesk"]},
+			{"username":"view","password":"view1234","addresses":[".*"],"roles":["viewer"]},
+			{"username":"ping","password":"pong1234","addresses":[".*"]}
+		]
+	}`)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile: authFilePath,
+	})
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+	do := func(method, path, body, user, pass string) string {
+		result, err := httpRequestWithBodyWithBasicAuth(method, base+path, body, user, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Helpdesk lists users, without credentials
+	result := do(http.MethodGet, "/users", "", "help", "help1234")
+	if !strings.Contains(result, `"username":"root"`) || strings.Contains(result, "toor1234") {
+		t.Fatalf("helpdesk list - expected users without passwords but got '%s'", result)
+	}
+	// Helpdesk adds users, but can't grant admin nor change admins
+	if result = do(http.MethodPost, "/user", `{"username":"new","password":"new12345","addresses":[".*"]}`, "help", "help1234"); result != "" {
+		t.Fatalf("helpdesk add - expected to pass but got '%s'", result)
+	}
+	if result = do(http.MethodPost, "/user", `{"username":"boss","password":"boss1234","addresses":[".*"],"is_admin":true}`, "help", "help1234"); result != "Forbidden\n" {
+		t.Fatalf("helpdesk add admin - expected 'Forbidden' but got '%s'", result)
+	}
+	if result = do(http.MethodPut, "/user", `{"username":"new","password":"new12345","addresses":[".*"],"allow_socks":true}`, "help", "help1234"); result != "Forbidden\n" {
+		t.Fatalf("helpdesk widen access - expected 'Forbidden' but got '%s'", result)
+	}
+	if result = do(http.MethodPut, "/user", `{"username":"root","password":"hacked1234"}`, "help", "help1234"); result != "Forbidden\n" {
+		t.Fatalf("helpdesk update admin - expected 'Forbidden' but got '%s'", result)
+	}
+	if result = do(http.MethodPut, "/group", `{"name":"helpdesk","roles":["admin"]}`, "help", "help1234"); result != "Forbidden\n" {
+		t.Fatalf("helpdesk grant - expected 'Forbidden' but got '%s'", result)
+	}
+	// Viewers and tunnel users can't change users
+	if result = do(http.MethodDelete, "/user/new", "", "view", "view1234"); result != "Forbidden\n" {
+		t.Fatalf("viewer delete - expected 'Forbidden' but got '%s'", result)
+	}
+	if result = do(http.MethodGet, "/users", "", "ping", "pong1234"); result != "Forbidden\n" {
+		t.Fatalf("tunnel user list - expected 'Forbidden' but got '%s'", result)
+	}
+	// Admins see credentials and manage groups
+	if result = do(http.MethodGet, "/user/help", "", "root", "toor1234"); !strings.Contains(result, "help1234") {
+		t.Fatalf("admin get - expected user with password but got '%s'", result)
+	}
+	if result = do(http.MethodDelete, "/group/helpdesk", "", "root", "toor1234"); !strings.HasPrefix(result, "Group still has members: help") {
+		t.Fatalf("delete group with members - expected conflict but got '%s'", result)
+	}
+	if result = do(http.MethodPut, "/group", `{"name":"ops","roles":["viewer"]}`, "root", "toor1234"); result != "" {
+		t.Fatalf("admin set group - expected to pass but got '%s'", result)
+	}
+	if b, _ := os.ReadFile(authFilePath); !strings.Contains(string(b), `"name": "ops"`) {
+		t.Fatalf("expected the group to be written to the authfile but got '%s'", b)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile:  authFilePath,
+		AuthDelay: time.Millisecond,
+	})
+	defer teardown()
+	url := "http://127.0.0.1:" + tl.GetServerPort() + "/me/password"
+
+	// Wrong current password - Must fail
+	result, err := httpRequestWithBodyWithBasicAuth(http.MethodPut, url, `{"current_password":"wrong","new_password":"newpong1234"}`, "ping", "pong1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "Current password is incorrect\n" {
+		t.Fatalf("wrong current password - expected to fail but got '%s'", result)
+	}
+
+	// Weak new password - Must fail
+	result, _ = httpRequestWithBodyWithBasicAuth(http.MethodPut, url, `{"current_password":"pong1234","new_password":"short"}`, "ping", "pong1234")
+	if !strings.HasPrefix(result, "password must have a minimum length") {
+		t.Fatalf("weak password - expected to fail but got '%s'", result)
+	}
+
+	// Non-admin changes its own password - Must pass
+	result, _ = httpRequestWithBodyWithBasicAuth(http.MethodPut, url, `{"current_password":"pong1234","new_password":"newpong1234"}`, "ping", "pong1234")
+	if result != "" {
+		t.Fatalf("valid change - expected to pass but got '%s'", result)
+	}
+	if result, _ = httpRequestWithBodyWithBasicAuth(http.MethodPut, url, `{}`, "ping", "pong1234"); result != "Unauthorized\n" {
+		t.Fatalf("old password - expected 'Unauthorized' but got '%s'", result)
+	}
+	if b, _ := os.ReadFile(authFilePath); !strings.Contains(string(b), "newpong1234") {
+		t.Fatalf("expected the new password to be written to the authfile")
+	}
+}
+
+func TestAuthfileRevisions(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	defer os.Remove(authFilePath)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile:  authFilePath,
+		AuthDelay: time.Millisecond,
+	})
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+	do := func(method, path, body, ifMatch string) (int, string) {
+		req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("root", "toor1234")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("ETag")
+	}
+
+	_, etag := do(http.MethodGet, "/users", "", "")
+	if etag == "" {
+		t.Fatalf("expected an ETag header on /users")
+	}
+
+	// Change based on the current revision - Must pass
+	code, newEtag := do(http.MethodPut, "/user", `{"username":"foo","password":"bar123456","addresses":["^9001"]}`, etag)
+	if code != http.StatusAccepted || newEtag == etag {
+		t.Fatalf("current revision - expected 202 with a new ETag but got %d '%s'", code, newEtag)
+	}
+
+	// Change based on a stale revision - Must fail
+	if code, _ = do(http.MethodPut, "/user", `{"username":"foo","password":"stale1234","addresses":["^9001"]}`, etag); code != http.StatusConflict {
+		t.Fatalf("stale revision - expected 409 but got %d", code)
+	}
+
+	// The write is atomic and records the revision
+	b, err := os.ReadFile(authFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := settings.DecodeAuthFile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.ETag(a.Revision) != newEtag {
+		t.Fatalf("expected revision %s in the authfile but got %d", newEtag, a.Revision)
+	}
+
+	// Edit the file behind the server's back, then change users
+	edited := strings.Replace(string(b), `"users": [`, `"users": [{"username":"baz","password":"baz12345","addresses":["^9004"]},`, 1)
+	if err := os.WriteFile(authFilePath, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ = do(http.MethodPut, "/user", `{"username":"foo","password":"edited1234","addresses":["^9001"]}`, newEtag); code != http.StatusConflict {
+		t.Fatalf("edited file - expected 409 but got %d", code)
+	}
+	if code, _ = do(http.MethodPost, "/user", `{"username":"qux","password":"qux12345","addresses":["^9005"]}`, ""); code != http.StatusCreated {
+		t.Fatalf("add user - expected 201 but got %d", code)
+	}
+	b, _ = os.ReadFile(authFilePath)
+	if !strings.Contains(string(b), `"baz"`) || !strings.Contains(string(b), `"qux"`) {
+		t.Fatalf("expected both the edit and the new user to be kept but got %s", b)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "users.db")
+	// An empty database would let every client in
+	if _, err := NewServer(&Config{AuthFile: dbPath, AuthStore: settings.StoreBolt}); err == nil {
+		t.Fatal("expected the server to refuse an empty user store")
+	}
+	tl := &testLayout{server: &Config{
+		AuthFile:  dbPath,
+		AuthStore: settings.StoreBolt,
+		Auth:      "root:toor1234",
+		AuthDelay: time.Millisecond,
+	}}
+	server, teardown := tl.setup(t)
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+
+	// Import an authfile into the empty database
+	authfile := `[
+		{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true},
+		{"username":"foo","password":"bar12345","addresses":["^9001"]}
+	]`
+	if result, err := httpRequestWithBodyWithBasicAuth(http.MethodPost, base+"/authfile", authfile, "root", "toor1234"); err != nil || result != "" {
+		t.Fatalf("import - expected to pass but got '%s' %v", result, err)
+	}
+	if result, _ := httpRequestWithBodyWithBasicAuth(http.MethodPost, base+"/user", `{"username":"ping","password":"pong1234","addresses":["^80"]}`, "root", "toor1234"); result != "" {
+		t.Fatalf("add user - expected to pass but got '%s'", result)
+	}
+	result, _ := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/user/ping", "foo", "bar12345")
+	if result != "Forbidden\n" {
+		t.Fatalf("expected foo to log in from the database, got '%s'", result)
+	}
+
+	// The users are kept in the database
+	server.Close()
+	store, err := settings.OpenBoltStore(dbPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	a, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Users) != 3 || a.Revision != 2 {
+		t.Fatalf("expected 3 users at revision 2 but got %d at %d", len(a.Users), a.Revision)
+	}
+}
+
+func TestAuthfileRollback(t *testing.T) {
+	authFilePath := createTempAuthFile(t)
+	teardown, tl := simpleSetup(t, &Config{
+		AuthFile:  authFilePath,
+		AuthDelay: time.Millisecond,
+	})
+	defer teardown()
+	base := "http://127.0.0.1:" + tl.GetServerPort()
+
+	// An upload which drops all other users
+	if result, _ := httpRequestWithBodyWithBasicAuth(http.MethodPost, base+"/authfile", `[{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true}]`, "root", "toor1234"); result != "" {
+		t.Fatalf("upload - expected to pass but got '%s'", result)
+	}
+	if result, _ := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/user/ping", "foo", "bar12345"); result != "Unauthorized\n" {
+		t.Fatalf("expected foo to be gone but got '%s'", result)
+	}
+
+	result, err := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/authfile/history", "root", "toor1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := []*settings.AuthFileVersion{}
+	if err := json.Unmarshal([]byte(result), &versions); err != nil {
+		t.Fatalf("failed to decode history '%s': %v", result, err)
+	}
+	if len(versions) != 1 || versions[0].Users != 3 {
+		t.Fatalf("expected the previous version in the history but got %s", result)
+	}
+
+	// Unknown revision - Must fail
+	if result, _ = httpRequestNoBodyWithBasicAuth(http.MethodPost, base+"/authfile/rollback/42", "root", "toor1234"); result != "Revision not found\n" {
+		t.Fatalf("unknown revision - expected 'Revision not found' but got '%s'", result)
+	}
+	// Rollback by a removed user - Must fail
+	if result, _ = httpRequestNoBodyWithBasicAuth(http.MethodPost, base+"/authfile/rollback/0", "ping", "pong1234"); result != "Unauthorized\n" {
+		t.Fatalf("removed user rollback - expected 'Unauthorized' but got '%s'", result)
+	}
+
+	rollback := base + "/authfile/rollback/" + strconv.FormatInt(versions[0].Revision, 10)
+	if result, _ = httpRequestNoBodyWithBasicAuth(http.MethodPost, rollback, "root", "toor1234"); result != "" {
+		t.Fatalf("rollback - expected to pass but got '%s'", result)
+	}
+	if result, _ := httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/user/ping", "foo", "bar12345"); result != "Forbidden\n" {
+		t.Fatalf("expected foo to be restored but got '%s'", result)
+	}
+	// The rollback itself can be undone
+	result, _ = httpRequestNoBodyWithBasicAuth(http.MethodGet, base+"/authfile/history", "root", "toor1234")
+	if err := json.Unmarshal([]byte(result), &versions); err != nil || len(versions) != 2 || versions[0].Users != 1 {
+		t.Fatalf("expected the rolled back version in the history but got %s", result)
+	}
+}
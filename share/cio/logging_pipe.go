@@ -12,16 +12,22 @@ type LoggingReadWriteCloser struct {
 	rw     io.ReadWriteCloser
 	logger *Logger
 	prefix string
+	redact *Redactor
 }
 
-func NewLoggingReadWriteCloser(rw io.ReadWriteCloser, logger *Logger, prefix string) *LoggingReadWriteCloser {
-	return &LoggingReadWriteCloser{rw: rw, logger: logger, prefix: prefix}
+// NewLoggingReadWriteCloser logs the traffic on rw, hiding
+// credentials using redact (or the DefaultRedactor when nil)
+func NewLoggingReadWriteCloser(rw io.ReadWriteCloser, logger *Logger, prefix string, redact *Redactor) *LoggingReadWriteCloser {
+	if redact == nil {
+		redact = DefaultRedactor
+	}
+	return &LoggingReadWriteCloser{rw: rw, logger: logger, prefix: prefix, redact: redact}
 }
 
 func (l *LoggingReadWriteCloser) Read(p []byte) (n int, err error) {
 	n, err = l.rw.Read(p)
 	if n > 0 {
-		l.logger.Infof("\n================== %s: Read ==================\n%s", l.prefix, formatOutput(p[:n]))
+		l.logger.Infof("\n================== %s: Read ==================\n%s", l.prefix, formatOutput(p[:n], l.redact))
 	}
 	return n, err
 }
@@ -29,7 +35,7 @@ func (l *LoggingReadWriteCloser) Read(p []byte) (n int, err error) {
 func (l *LoggingReadWriteCloser) Write(p []byte) (n int, err error) {
 	n, err = l.rw.Write(p)
 	if n > 0 {
-		l.logger.Infof("\n================== %s: Write ==================\n%s", l.prefix, formatOutput(p[:n]))
+		l.logger.Infof("\n================== %s: Write ==================\n%s", l.prefix, formatOutput(p[:n], l.redact))
 	}
 	return n, err
 }
@@ -38,12 +44,12 @@ func (l *LoggingReadWriteCloser) Close() error {
 	return l.rw.Close()
 }
 
-func formatOutput(data []byte) string {
+func formatOutput(data []byte, redact *Redactor) string {
 	const maxLogLength = 1024
 	if len(data) > maxLogLength {
 		data = data[:maxLogLength]
 	}
-	return fmt.Sprintf("%s", data)
+	return fmt.Sprintf("%s", redact.Bytes(data))
 }
 
 func LoggingPipe(src io.ReadWriteCloser, dst io.ReadWriteCloser) (int64, int64) {
@@ -0,0 +1,121 @@
+package cio
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// Redacted replaces the values hidden by a Redactor
+const Redacted = "[REDACTED]"
+
+// builtinHeaders always carry credentials
+var builtinHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Csrf-Token",
+}
+
+// builtinFields matches the JSON fields which usually carry credentials
+const builtinFields = `^(password|passwd|secret|token|api[_-]?key|access[_-]?token|refresh[_-]?token|client[_-]?secret)$`
+
+var (
+	headerLine = regexp.MustCompile(`(?m)^([A-Za-z0-9-]+):[ \t]*([^\r\n]*)`)
+	jsonField  = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\s*:\s*("(?:[^"\\]|\\.)*"|-?[0-9][0-9.eE+-]*|true|false|null)`)
+)
+
+// Redactor hides credentials from inspection and recording
+// output, replacing the values of sensitive headers and
+// JSON fields, whose names are matched case-insensitively
+type Redactor struct {
+	headers []*regexp.Regexp
+	fields  []*regexp.Regexp
+}
+
+// DefaultRedactor only applies the built-in rules
+var DefaultRedactor, _ = NewRedactor(nil, nil)
+
+// NewRedactor creates a Redactor applying the built-in rules,
+// plus regular expressions matching header and JSON field names
+func NewRedactor(headers, fields []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, h := range builtinHeaders {
+		headers = append(headers, "^"+regexp.QuoteMeta(h)+"$")
+	}
+	fields = append(fields, builtinFields)
+	for _, h := range headers {
+		re, err := regexp.Compile("(?i)" + h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header rule '%s': %s", h, err)
+		}
+		r.headers = append(r.headers, re)
+	}
+	for _, f := range fields {
+		re, err := regexp.Compile("(?i)" + f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field rule '%s': %s", f, err)
+		}
+		r.fields = append(r.fields, re)
+	}
+	return r, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns a copy of h with sensitive values replaced
+func (r *Redactor) Header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	out := h.Clone()
+	for k, vs := range out {
+		if matchAny(r.headers, k) {
+			for i := range vs {
+				vs[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// Bytes replaces sensitive header values and JSON fields found
+// in raw traffic. The input is returned when nothing matched.
+// Fields split across separate calls are not recognized.
+func (r *Redactor) Bytes(data []byte) []byte {
+	data = replaceGroup(headerLine, data, func(name string) bool {
+		return matchAny(r.headers, name)
+	}, []byte(Redacted))
+	return replaceGroup(jsonField, data, func(name string) bool {
+		return matchAny(r.fields, name)
+	}, []byte(`"`+Redacted+`"`))
+}
+
+// replaceGroup replaces the second group of each match
+// of re, when its first group is accepted by match
+func replaceGroup(re *regexp.Regexp, data []byte, match func(string) bool, with []byte) []byte {
+	var out []byte
+	last := 0
+	for _, m := range re.FindAllSubmatchIndex(data, -1) {
+		if !match(string(data[m[2]:m[3]])) || m[4] == m[5] {
+			continue
+		}
+		out = append(out, data[last:m[4]]...)
+		out = append(out, with...)
+		last = m[5]
+	}
+	if out == nil {
+		return data
+	}
+	return append(out, data[last:]...)
+}
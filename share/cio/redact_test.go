@@ -0,0 +1,45 @@
+package cio
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRedactBytes(t *testing.T) {
+	r, err := NewRedactor([]string{"^X-Secret-"}, []string{"^card$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		in, want string
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\nAuthorization: Basic Zm9v\r\n\r\n",
+			"GET / HTTP/1.1\r\nHost: a\r\nAuthorization: [REDACTED]\r\n\r\n"},
+		{"cookie: a=b\r\nX-Secret-Key: 42\r\n",
+			"cookie: [REDACTED]\r\nX-Secret-Key: [REDACTED]\r\n"},
+		{`{"user":"bob","Password":"hunter2","card":4242,"nested":{"token":"a\"b"}}`,
+			`{"user":"bob","Password":"[REDACTED]","card":"[REDACTED]","nested":{"token":"[REDACTED]"}}`},
+		{"no credentials here", "no credentials here"},
+	} {
+		if got := string(r.Bytes([]byte(c.in))); got != c.want {
+			t.Errorf("expected\n  %q\ngot\n  %q", c.want, got)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{"Set-Cookie": {"a=b", "c=d"}, "Accept": {"*/*"}}
+	got := DefaultRedactor.Header(h)
+	if got.Get("Accept") != "*/*" || got.Values("Set-Cookie")[1] != Redacted {
+		t.Fatalf("unexpected header %v", got)
+	}
+	if h.Get("Set-Cookie") != "a=b" {
+		t.Fatalf("original header modified")
+	}
+}
+
+func TestRedactInvalidRule(t *testing.T) {
+	if _, err := NewRedactor([]string{"("}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,39 @@
+package cnet
+
+import (
+	"net"
+)
+
+// IPFilter admits peers by their ip, deny
+// takes precedence, and a non-empty allow
+// list must contain the peer
+type IPFilter struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// Permits checks whether the peer at addr is admitted
+func (f *IPFilter) Permits(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		//unix sockets have no peer ip
+		return len(f.Allow) == 0
+	}
+	for _, n := range f.Deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, n := range f.Allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,333 @@
+package cnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrustedProxies describes the proxies and load balancers
+// in front of a listener, whose reports of the real client
+// address are believed
+type TrustedProxies struct {
+	//CIDRs lists the trusted peers, whose X-Forwarded-For
+	//and PROXY protocol headers are honored
+	CIDRs []*net.IPNet
+	//ProxyProtocol accepts PROXY protocol (v1 and v2)
+	//headers at the start of each connection
+	ProxyProtocol bool
+	//Timeout bounds the time taken to send the header
+	Timeout time.Duration
+}
+
+// Trusts checks whether the given address belongs to a trusted proxy
+func (t *TrustedProxies) Trusts(addr net.Addr) bool {
+	if t == nil {
+		return false
+	}
+	ip := addrIP(addr)
+	for _, n := range t.CIDRs {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener wraps l, so that connections report the address
+// sent by trusted proxies using the PROXY protocol
+func (t *TrustedProxies) Listener(l net.Listener) net.Listener {
+	if t == nil || !t.ProxyProtocol || len(t.CIDRs) == 0 {
+		return l
+	}
+	return &proxyProtoListener{
+		Listener: l,
+		t:        t,
+		accepted: make(chan accepted),
+		closed:   make(chan struct{}),
+	}
+}
+
+// ClientIP returns the ip of the client of r, which is the last
+// address in X-Forwarded-For not added by a trusted proxy
+func (t *TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	peer := &net.TCPAddr{IP: net.ParseIP(host)}
+	if !t.Trusts(peer) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		host = ip.String()
+		if !t.Trusts(&net.TCPAddr{IP: ip}) {
+			break
+		}
+	}
+	return host
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	t         *TrustedProxies
+	start     sync.Once
+	accepted  chan accepted
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+// Accept returns connections once their header is read, which
+// happens in the background so that slow peers don't hold up others
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	l.start.Do(func() { go l.acceptLoop() })
+	select {
+	case a := <-l.accepted:
+		return a.conn, a.err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyProtoListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.deliver(accepted{err: err})
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !l.t.Trusts(c.RemoteAddr()) {
+			l.deliver(accepted{conn: c})
+			continue
+		}
+		go func() {
+			pc, err := l.handshake(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			l.deliver(accepted{conn: pc})
+		}()
+	}
+}
+
+func (l *proxyProtoListener) deliver(a accepted) {
+	select {
+	case l.accepted <- a:
+	case <-l.closed:
+		if a.conn != nil {
+			a.conn.Close()
+		}
+	}
+}
+
+// handshake reads the optional header sent by a trusted peer.
+// A peer which sends nothing before the timeout is passed on
+// as is, since it may expect the server to speak first.
+func (l *proxyProtoListener) handshake(c net.Conn) (net.Conn, error) {
+	if l.t.Timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(l.t.Timeout))
+	}
+	r := bufio.NewReader(c)
+	src, dst, err := readProxyHeader(r)
+	if err != nil {
+		var ne net.Error
+		if !errors.As(err, &ne) || !ne.Timeout() || r.Buffered() > 0 {
+			return nil, err
+		}
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &proxyProtoConn{Conn: c, r: r, src: src, dst: dst}, nil
+}
+
+func (l *proxyProtoListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// proxyProtoConn reports the addresses sent in its PROXY
+// protocol header, and reads what followed the header
+type proxyProtoConn struct {
+	net.Conn
+	r   *bufio.Reader
+	src net.Addr
+	dst net.Addr
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	if c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// readProxyHeader consumes a PROXY protocol header if one is
+// present, returning nil addresses when there is none, or when
+// the header does not describe a proxied connection. It stops
+// waiting as soon as a byte matches neither signature.
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		v1 := n <= len(proxyV1Prefix) && bytes.Equal(b, proxyV1Prefix[:n])
+		v2 := n <= len(proxyV2Sig) && bytes.Equal(b, proxyV2Sig[:n])
+		switch {
+		case v1 && n == len(proxyV1Prefix):
+			return readProxyV1(r)
+		case v2 && n == len(proxyV2Sig):
+			return readProxyV2(r)
+		case !v1 && !v2:
+			return nil, nil, nil
+		}
+	}
+}
+
+var errProxyHeader = errors.New("invalid proxy protocol header")
+
+func readProxyV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	//the v1 header is at most 107 bytes
+	line := make([]byte, 0, 107)
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	fields := strings.Fields(strings.TrimSuffix(string(line), "\r\n"))
+	if !bytes.HasSuffix(line, []byte("\r\n")) || len(fields) < 2 {
+		return nil, nil, errProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errProxyHeader
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || err1 != nil || err2 != nil {
+		return nil, nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, &net.TCPAddr{IP: dstIP, Port: int(dstPort)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, nil, err
+	}
+	if head[12]>>4 != 2 {
+		return nil, nil, errProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	//LOCAL connections (e.g. health checks) keep their address
+	if head[12]&0xf == 0 {
+		return nil, nil, nil
+	}
+	var ipLen int
+	switch head[13] >> 4 {
+	case 1:
+		ipLen = 4
+	case 2:
+		ipLen = 16
+	default:
+		//unix and unspecified families carry no ip address
+		return nil, nil, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, errProxyHeader
+	}
+	srcIP := net.IP(body[:ipLen])
+	dstIP := net.IP(body[ipLen : 2*ipLen])
+	ports := body[2*ipLen:]
+	return &net.TCPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(ports))},
+		&net.TCPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(ports[2:]))}, nil
+}
+
+// WithRemoteIP overrides the ip reported by the
+// RemoteAddr of c, for example with a ClientIP
+func WithRemoteIP(c net.Conn, ip string) net.Conn {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if a, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		if a.IP.Equal(addr.IP) {
+			return c
+		}
+		addr.Port = a.Port
+	}
+	if addr.IP == nil {
+		return c
+	}
+	return &remoteIPConn{Conn: c, addr: addr}
+}
+
+type remoteIPConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *remoteIPConn) RemoteAddr() net.Addr {
+	return c.addr
+}
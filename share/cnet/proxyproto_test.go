@@ -0,0 +1,109 @@
+package cnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func proxyListener(t *testing.T, cidr string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, n, _ := net.ParseCIDR(cidr)
+	l = (&TrustedProxies{CIDRs: []*net.IPNet{n}, ProxyProtocol: true, Timeout: 200 * time.Millisecond}).Listener(l)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dialAndAccept sends data from a new peer, returning the accepted
+// connection with the first n bytes which followed any header
+func dialAndAccept(t *testing.T, l net.Listener, data []byte, n int) (net.Conn, string) {
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s, b); err != nil {
+		t.Fatal(err)
+	}
+	return s, string(b)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	l := proxyListener(t, "127.0.0.0/8")
+	//v1
+	s, rest := dialAndAccept(t, l, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\r\nhello"), 5)
+	if s.RemoteAddr().String() != "203.0.113.7:5555" || s.LocalAddr().String() != "10.0.0.1:443" || rest != "hello" {
+		t.Fatalf("unexpected v1 result %s %s %q", s.RemoteAddr(), s.LocalAddr(), rest)
+	}
+	//v2
+	v2 := append([]byte{}, proxyV2Sig...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 198, 51, 100, 1, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 6666)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+	s, rest = dialAndAccept(t, l, append(v2, "hello"...), 5)
+	if s.RemoteAddr().String() != "198.51.100.1:6666" || rest != "hello" {
+		t.Fatalf("unexpected v2 result %s %q", s.RemoteAddr(), rest)
+	}
+	//no header, even one starting like a header
+	for _, data := range []string{"GET / HTTP/1.1\r\n", "PRI * HTTP/2.0", "\r\n\r\nfoo"} {
+		s, rest = dialAndAccept(t, l, []byte(data), len(data))
+		if !s.RemoteAddr().(*net.TCPAddr).IP.IsLoopback() || rest != data {
+			t.Fatalf("unexpected result without header %s %q", s.RemoteAddr(), rest)
+		}
+	}
+}
+
+func TestProxyProtocolServerFirst(t *testing.T) {
+	l := proxyListener(t, "127.0.0.0/8")
+	//the peer waits for the server to speak
+	quiet, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+	//and doesn't hold up other peers meanwhile
+	_, rest := dialAndAccept(t, l, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\r\nhello"), 5)
+	if rest != "hello" {
+		t.Fatalf("unexpected result %q", rest)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.RemoteAddr().(*net.TCPAddr).IP.IsLoopback() {
+		t.Fatalf("expected the peer's own address, got %s", s.RemoteAddr())
+	}
+	if _, err := s.Write([]byte("220 ready\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 11)
+	if _, err := io.ReadFull(quiet, b); err != nil || !bytes.Equal(b, []byte("220 ready\r\n")) {
+		t.Fatalf("expected the greeting, got %q %v", b, err)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	l := proxyListener(t, "10.0.0.0/8")
+	header := "PROXY TCP4 203.0.113.7 10.0.0.1 5555 443\r\n"
+	s, rest := dialAndAccept(t, l, []byte(header), len(header))
+	if !s.RemoteAddr().(*net.TCPAddr).IP.IsLoopback() || rest != header {
+		t.Fatalf("expected the header to be left alone, got %s %q", s.RemoteAddr(), rest)
+	}
+}
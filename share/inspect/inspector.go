@@ -0,0 +1,246 @@
+package inspect
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// Inspector keeps the most recent exchanges
+// and serves them in a web UI
+type Inspector struct {
+	mu        sync.Mutex
+	max       int
+	nextID    int64
+	exchanges []*Exchange
+}
+
+// NewInspector creates an Inspector holding up to max exchanges
+func NewInspector(max int) *Inspector {
+	return &Inspector{max: max}
+}
+
+// Add stores a completed exchange, evicting the oldest
+func (i *Inspector) Add(ex *Exchange) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.nextID++
+	ex.ID = i.nextID
+	i.exchanges = append(i.exchanges, ex)
+	if len(i.exchanges) > i.max {
+		i.exchanges = i.exchanges[len(i.exchanges)-i.max:]
+	}
+}
+
+// Clear removes all stored exchanges
+func (i *Inspector) Clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.exchanges = nil
+}
+
+// Filter selects exchanges by remote, method, status (e.g. 404
+// or 4xx) and a search term matched against host and url
+type Filter struct {
+	Remote string
+	Method string
+	Status string
+	Search string
+}
+
+// FilterFromQuery reads a Filter from url query parameters
+func FilterFromQuery(r *http.Request) Filter {
+	q := r.URL.Query()
+	return Filter{
+		Remote: q.Get("remote"),
+		Method: q.Get("method"),
+		Status: q.Get("status"),
+		Search: q.Get("q"),
+	}
+}
+
+// Match checks whether ex passes the filter
+func (f Filter) Match(ex *Exchange) bool {
+	if f.Remote != "" && ex.Remote != f.Remote {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(ex.Method, f.Method) {
+		return false
+	}
+	if s := strings.ToLower(f.Status); s != "" {
+		code := strconv.Itoa(ex.Status)
+		if strings.HasSuffix(s, "xx") {
+			if !strings.HasPrefix(code, s[:len(s)-2]) {
+				return false
+			}
+		} else if code != s {
+			return false
+		}
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(ex.Host+ex.URL), strings.ToLower(f.Search)) {
+		return false
+	}
+	return true
+}
+
+// List returns the stored exchanges passing the filter, newest first
+func (i *Inspector) List(f Filter) []*Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	list := []*Exchange{}
+	for j := len(i.exchanges) - 1; j >= 0; j-- {
+		if ex := i.exchanges[j]; f.Match(ex) {
+			list = append(list, ex)
+		}
+	}
+	return list
+}
+
+// Get finds a stored exchange by id
+func (i *Inspector) Get(id int64) (*Exchange, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, ex := range i.exchanges {
+		if ex.ID == id {
+			return ex, true
+		}
+	}
+	return nil, false
+}
+
+// summary is the list view of an exchange
+type summary struct {
+	ID           int64   `json:"id"`
+	Remote       string  `json:"remote"`
+	Start        string  `json:"start"`
+	DurationMS   float64 `json:"duration_ms"`
+	Method       string  `json:"method"`
+	Host         string  `json:"host"`
+	URL          string  `json:"url"`
+	Status       int     `json:"status"`
+	RequestSize  int64   `json:"request_size"`
+	ResponseSize int64   `json:"response_size"`
+}
+
+// detail is the full view of an exchange, with decoded bodies
+type detail struct {
+	summary
+	Proto          string      `json:"proto"`
+	RequestHeader  http.Header `json:"request_header"`
+	RequestBody    string      `json:"request_body"`
+	ResponseHeader http.Header `json:"response_header"`
+	ResponseBody   string      `json:"response_body"`
+}
+
+func summarize(ex *Exchange) summary {
+	return summary{
+		ID:           ex.ID,
+		Remote:       ex.Remote,
+		Start:        ex.Start.Format("15:04:05.000"),
+		DurationMS:   float64(ex.Duration.Microseconds()) / 1000,
+		Method:       ex.Method,
+		Host:         ex.Host,
+		URL:          ex.URL,
+		Status:       ex.Status,
+		RequestSize:  ex.RequestSize,
+		ResponseSize: ex.ResponseSize,
+	}
+}
+
+// ServeHTTP serves the web UI and its JSON API:
+//
+//	GET    /api/exchanges       list, filtered by remote, method, status and q
+//	GET    /api/exchanges/<id>  details, with decoded bodies
+//	DELETE /api/exchanges       clear
+func (i *Inspector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "" || path == "/index.html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(uiHTML))
+	case path == "/api/exchanges" && r.Method == http.MethodDelete:
+		i.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/api/exchanges":
+		list := []summary{}
+		for _, ex := range i.List(FilterFromQuery(r)) {
+			list = append(list, summarize(ex))
+		}
+		writeJSON(w, list)
+	case strings.HasPrefix(path, "/api/exchanges/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(path, "/api/exchanges/"), 10, 64)
+		ex, found := i.Get(id)
+		if !found {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, detail{
+			summary:        summarize(ex),
+			Proto:          ex.Proto,
+			RequestHeader:  ex.RequestHeader,
+			RequestBody:    DecodeBody(ex.RequestHeader, ex.RequestBody),
+			ResponseHeader: ex.ResponseHeader,
+			ResponseBody:   DecodeBody(ex.ResponseHeader, ex.ResponseBody),
+		})
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// DecodeBody renders a body for display, undoing gzip and
+// deflate encodings and pretty-printing JSON
+func DecodeBody(h http.Header, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if b, ok := decompress(h, body); ok {
+		body = b
+	}
+	if strings.Contains(h.Get("Content-Type"), "json") || json.Valid(body) {
+		pretty := &bytes.Buffer{}
+		if json.Indent(pretty, body, "", "  ") == nil {
+			return pretty.String()
+		}
+	}
+	if !utf8.Valid(body) {
+		return "<binary, " + strconv.Itoa(len(body)) + " bytes>"
+	}
+	return string(body)
+}
+
+// decompress undoes gzip and deflate content encodings, giving
+// up on bodies which expand beyond INSPECT_MAX_BODY bytes
+func decompress(h http.Header, body []byte) ([]byte, bool) {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(h.Get("Content-Encoding")) {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r = flate.NewReader(bytes.NewReader(body))
+	}
+	if r == nil || err != nil {
+		return nil, false
+	}
+	//truncated bodies decode as far as possible
+	max := int64(settings.EnvInt("INSPECT_MAX_BODY", 1<<20))
+	b, _ := io.ReadAll(io.LimitReader(r, max+1))
+	if int64(len(b)) > max {
+		return nil, false
+	}
+	return b, len(b) > 0
+}
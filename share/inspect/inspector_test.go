@@ -0,0 +1,113 @@
+package inspect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+)
+
+func TestDecodeBody(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write([]byte(`{"a":[1,2]}`))
+	w.Close()
+	for _, c := range []struct {
+		header http.Header
+		body   []byte
+		want   string
+	}{
+		{http.Header{}, []byte("hello"), "hello"},
+		{http.Header{}, []byte(`{"a":1}`), "{\n  \"a\": 1\n}"},
+		{http.Header{"Content-Encoding": {"gzip"}}, gz.Bytes(), "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{http.Header{}, []byte{0xff, 0xfe, 0x00}, "<binary, 3 bytes>"},
+	} {
+		if got := DecodeBody(c.header, c.body); got != c.want {
+			t.Errorf("expected %q, got %q", c.want, got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ex := &Exchange{Remote: "R:80=>3000", Method: "GET", Host: "app.local", URL: "/api/users", Status: 404}
+	for _, c := range []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{Method: "get"}, true},
+		{Filter{Method: "POST"}, false},
+		{Filter{Status: "4xx"}, true},
+		{Filter{Status: "404"}, true},
+		{Filter{Status: "5xx"}, false},
+		{Filter{Search: "USERS"}, true},
+		{Filter{Remote: "R:81=>3000"}, false},
+	} {
+		if got := c.filter.Match(ex); got != c.want {
+			t.Errorf("%+v: expected %v, got %v", c.filter, c.want, got)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write([]byte(`{"token":"abc","id":1}`))
+	w.Close()
+	ex := &Exchange{
+		RequestHeader:  http.Header{"Authorization": {"Bearer abc"}},
+		RequestBody:    []byte(`{"password":"hunter2"}`),
+		RequestSize:    22,
+		ResponseHeader: http.Header{"Content-Encoding": {"gzip"}},
+		ResponseBody:   gz.Bytes(),
+		ResponseSize:   int64(gz.Len()),
+	}
+	Redact(cio.DefaultRedactor, ex)
+	if ex.RequestHeader.Get("Authorization") != cio.Redacted ||
+		string(ex.RequestBody) != `{"password":"[REDACTED]"}` || !ex.Redacted {
+		t.Fatalf("unexpected request %v %s", ex.RequestHeader, ex.RequestBody)
+	}
+	if ex.ResponseHeader.Get("Content-Encoding") != "" ||
+		string(ex.ResponseBody) != `{"token":"[REDACTED]","id":1}` || ex.ResponseSize != int64(len(ex.ResponseBody)) {
+		t.Fatalf("unexpected response %v %s", ex.ResponseHeader, ex.ResponseBody)
+	}
+	if _, err := Replay(http.DefaultClient, "http://127.0.0.1:1", ex); err == nil || !strings.Contains(err.Error(), "redacted") {
+		t.Fatalf("expected the redacted request not to be replayed, got %v", err)
+	}
+	//requests without credentials are kept replayable
+	gz.Reset()
+	w.Reset(gz)
+	w.Write([]byte(`{"id":1}`))
+	w.Close()
+	ex = &Exchange{
+		RequestHeader: http.Header{"Content-Encoding": {"gzip"}},
+		RequestBody:   gz.Bytes(),
+		RequestSize:   int64(gz.Len()),
+	}
+	Redact(cio.DefaultRedactor, ex)
+	if ex.Redacted || string(ex.RequestBody) != `{"id":1}` || ex.RequestSize != 8 {
+		t.Fatalf("unexpected request %+v", ex)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write(make([]byte, 2<<20))
+	w.Close()
+	h := http.Header{"Content-Encoding": {"gzip"}}
+	if _, ok := decompress(h, gz.Bytes()); ok {
+		t.Fatal("expected the body to exceed the limit")
+	}
+	if got := DecodeBody(h, gz.Bytes()); !strings.HasPrefix(got, "<binary") {
+		t.Fatalf("expected the body to be left encoded, got %.20q", got)
+	}
+	ex := &Exchange{ResponseHeader: h, ResponseBody: gz.Bytes(), ResponseSize: int64(gz.Len())}
+	Redact(cio.DefaultRedactor, ex)
+	if ex.ResponseHeader.Get("Content-Encoding") != "gzip" || ex.ResponseSize != int64(gz.Len()) {
+		t.Fatalf("expected the body to be left encoded, got %v", ex.ResponseHeader)
+	}
+}
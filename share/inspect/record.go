@@ -0,0 +1,311 @@
+package inspect
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	chshare "github.com/NextChapterSoftware/chissl/share"
+)
+
+// Recording formats
+const (
+	FormatJSON = "json"
+	FormatHAR  = "har"
+)
+
+// Recorder saves each exchange as a file in a directory
+type Recorder struct {
+	dir    string
+	format string
+	mu     sync.Mutex
+	seq    int
+}
+
+// NewRecorder creates the directory, and a Recorder writing
+// exchanges to it in the given format (json or har)
+func NewRecorder(dir, format string) (*Recorder, error) {
+	if format == "" {
+		format = FormatJSON
+	}
+	if format != FormatJSON && format != FormatHAR {
+		return nil, fmt.Errorf("unknown recording format '%s'", format)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir, format: format}, nil
+}
+
+// Save writes the exchange to a new file, named so
+// that files sort in the order they were recorded
+func (r *Recorder) Save(ex *Exchange) error {
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+	var v interface{} = ex
+	if r.format == FormatHAR {
+		v = toHAR(ex)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%06d-%s.%s", ex.Start.UTC().Format("20060102T150405.000"), seq, strings.ToLower(ex.Method), r.format)
+	return os.WriteFile(filepath.Join(r.dir, name), b, 0600)
+}
+
+// Load reads the exchanges recorded in the given files or
+// directories, in both formats, in the order they were recorded
+func Load(paths ...string) ([]*Exchange, error) {
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		for _, ext := range []string{FormatJSON, FormatHAR} {
+			matches, _ := filepath.Glob(filepath.Join(p, "*."+ext))
+			files = append(files, matches...)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	var list []*Exchange
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		exs, err := decodeRecording(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		list = append(list, exs...)
+	}
+	return list, nil
+}
+
+// decodeRecording reads an exchange, or the entries of a HAR log
+func decodeRecording(b []byte) ([]*Exchange, error) {
+	var probe struct {
+		Log *json.RawMessage `json:"log"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Log == nil {
+		ex := &Exchange{}
+		if err := json.Unmarshal(b, ex); err != nil {
+			return nil, err
+		}
+		return []*Exchange{ex}, nil
+	}
+	h := &har{}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, err
+	}
+	var list []*Exchange
+	for _, e := range h.Log.Entries {
+		ex, err := fromHAR(e)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ex)
+	}
+	return list, nil
+}
+
+// har is an HTTP Archive (v1.2) holding recorded exchanges
+type har struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Comment         string      `json:"comment,omitempty"`
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Headers     []harPair   `json:"headers"`
+	QueryString []harPair   `json:"queryString"`
+	Cookies     []harPair   `json:"cookies"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+	PostData    *harContent `json:"postData,omitempty"`
+	//custom fields are prefixed with an underscore
+	Redacted bool `json:"_redacted,omitempty"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Headers     []harPair  `json:"headers"`
+	Cookies     []harPair  `json:"cookies"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int64      `json:"bodySize"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func toHAR(ex *Exchange) *har {
+	h := &har{}
+	h.Log.Version = "1.2"
+	h.Log.Creator.Name = "chissl"
+	h.Log.Creator.Version = chshare.BuildVersion
+	e := harEntry{
+		Comment:         ex.Remote,
+		StartedDateTime: ex.Start,
+		Time:            float64(ex.Duration.Microseconds()) / 1000,
+		Request: harRequest{
+			Method:      ex.Method,
+			URL:         "http://" + ex.Host + ex.URL,
+			HTTPVersion: ex.Proto,
+			Headers:     harHeaders(ex.RequestHeader),
+			QueryString: []harPair{},
+			Cookies:     []harPair{},
+			HeadersSize: -1,
+			BodySize:    ex.RequestSize,
+			Redacted:    ex.Redacted,
+		},
+		Response: harResponse{
+			Status:      ex.Status,
+			StatusText:  http.StatusText(ex.Status),
+			HTTPVersion: ex.Proto,
+			Headers:     harHeaders(ex.ResponseHeader),
+			Cookies:     []harPair{},
+			Content:     harBody(ex.ResponseHeader, ex.ResponseBody, ex.ResponseSize),
+			HeadersSize: -1,
+			BodySize:    ex.ResponseSize,
+		},
+	}
+	if u, err := url.ParseRequestURI(ex.URL); err == nil {
+		for k, vs := range u.Query() {
+			for _, v := range vs {
+				e.Request.QueryString = append(e.Request.QueryString, harPair{k, v})
+			}
+		}
+	}
+	if ex.RequestSize > 0 {
+		c := harBody(ex.RequestHeader, ex.RequestBody, ex.RequestSize)
+		e.Request.PostData = &c
+	}
+	e.Timings.Wait = e.Time
+	h.Log.Entries = []harEntry{e}
+	return h
+}
+
+func harHeaders(h http.Header) []harPair {
+	pairs := []harPair{}
+	for k, vs := range h {
+		for _, v := range vs {
+			pairs = append(pairs, harPair{k, v})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+func harBody(h http.Header, body []byte, size int64) harContent {
+	c := harContent{Size: size, MimeType: h.Get("Content-Type"), Text: string(body)}
+	if !utf8.Valid(body) {
+		c.Text = base64.StdEncoding.EncodeToString(body)
+		c.Encoding = "base64"
+	}
+	return c
+}
+
+func fromHAR(e harEntry) (*Exchange, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("request url has no host")
+	}
+	ex := &Exchange{
+		Remote:         e.Comment,
+		Start:          e.StartedDateTime,
+		Duration:       time.Duration(e.Time * float64(time.Millisecond)),
+		Method:         e.Request.Method,
+		URL:            u.RequestURI(),
+		Host:           u.Host,
+		Proto:          e.Request.HTTPVersion,
+		RequestHeader:  http.Header{},
+		RequestSize:    e.Request.BodySize,
+		Redacted:       e.Request.Redacted,
+		Status:         e.Response.Status,
+		ResponseHeader: http.Header{},
+		ResponseSize:   e.Response.BodySize,
+	}
+	for _, p := range e.Request.Headers {
+		ex.RequestHeader.Add(p.Name, p.Value)
+	}
+	for _, p := range e.Response.Headers {
+		ex.ResponseHeader.Add(p.Name, p.Value)
+	}
+	if e.Request.PostData != nil {
+		if ex.RequestBody, err = harText(*e.Request.PostData); err != nil {
+			return nil, err
+		}
+	}
+	if ex.ResponseBody, err = harText(e.Response.Content); err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
+
+func harText(c harContent) ([]byte, error) {
+	if c.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(c.Text)
+	}
+	return []byte(c.Text), nil
+}
@@ -0,0 +1,74 @@
+package inspect
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	//the target echoes the body upper-cased
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.Host + " " + strings.ToUpper(string(b))))
+	}))
+	defer target.Close()
+	ex := &Exchange{
+		Remote:         "R:80=>3000",
+		Start:          time.Now(),
+		Method:         "POST",
+		URL:            "/hook?id=1",
+		Host:           "app.local",
+		Proto:          "HTTP/1.1",
+		RequestHeader:  http.Header{"Content-Type": {"text/plain"}},
+		RequestBody:    []byte("event"),
+		RequestSize:    5,
+		Status:         200,
+		ResponseHeader: http.Header{"Content-Type": {"text/plain"}},
+		ResponseBody:   []byte("app.local EVENT"),
+		ResponseSize:   15,
+	}
+	for _, format := range []string{FormatJSON, FormatHAR} {
+		dir := t.TempDir()
+		r, err := NewRecorder(dir, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Save(ex); err != nil {
+			t.Fatal(err)
+		}
+		list, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("%s: expected 1 exchange, got %d", format, len(list))
+		}
+		got := list[0]
+		if got.URL != ex.URL || got.Host != ex.Host || !reflect.DeepEqual(got.RequestBody, ex.RequestBody) ||
+			got.Remote != ex.Remote || got.RequestHeader.Get("Content-Type") != "text/plain" {
+			t.Fatalf("%s: unexpected exchange %+v", format, got)
+		}
+		replayed, err := Replay(target.Client(), target.URL, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := Diff(got, replayed); len(diff) != 0 {
+			t.Fatalf("%s: unexpected diff %q", format, diff)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &Exchange{Status: 200, ResponseBody: []byte("a\nb\nc")}
+	b := &Exchange{Status: 500, ResponseBody: []byte("a\nc\nd")}
+	expected := []string{"status: 200 => 500", "- b", "+ d"}
+	if got := Diff(a, b); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,35 @@
+package inspect
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+)
+
+// Redact hides the credentials carried by ex, in its headers
+// and bodies. Compressed bodies are kept decompressed, so
+// that their fields can be redacted too. Sizes are updated
+// to match the bodies kept, and exchanges whose request lost
+// values are marked as Redacted, since they can't be replayed.
+func Redact(r *cio.Redactor, ex *Exchange) {
+	ex.RequestHeader, ex.RequestBody, ex.RequestSize, ex.Redacted = redactMessage(r, ex.RequestHeader, ex.RequestBody, ex.RequestSize)
+	ex.ResponseHeader, ex.ResponseBody, ex.ResponseSize, _ = redactMessage(r, ex.ResponseHeader, ex.ResponseBody, ex.ResponseSize)
+}
+
+func redactMessage(r *cio.Redactor, h http.Header, body []byte, size int64) (http.Header, []byte, int64, bool) {
+	complete := size == int64(len(body))
+	redacted := r.Header(h)
+	changed := !reflect.DeepEqual(redacted, h)
+	if b, ok := decompress(redacted, body); ok {
+		redacted.Del("Content-Encoding")
+		body = b
+	}
+	b := r.Bytes(body)
+	changed = changed || !bytes.Equal(b, body)
+	if complete {
+		size = int64(len(b))
+	}
+	return redacted, b, size, changed
+}
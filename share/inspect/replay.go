@@ -0,0 +1,133 @@
+package inspect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// hopHeaders are not replayed, since they describe
+// the recorded connection rather than the request
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Te",
+	"Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// Replay re-sends the recorded request of ex to the target
+// base url, returning the exchange it produced. The recorded
+// Host header is kept, so that virtual hosts still match.
+func Replay(client *http.Client, target string, ex *Exchange) (*Exchange, error) {
+	base, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	u, err := base.Parse(ex.URL)
+	if err != nil {
+		return nil, err
+	}
+	if ex.Redacted {
+		return nil, errors.New("recorded request was redacted, record it with --record-raw to replay it")
+	}
+	if ex.RequestSize > int64(len(ex.RequestBody)) {
+		return nil, fmt.Errorf("recorded request body was truncated (%d of %d bytes)", len(ex.RequestBody), ex.RequestSize)
+	}
+	req, err := http.NewRequest(ex.Method, u.String(), bytes.NewReader(ex.RequestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = ex.RequestHeader.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	req.Host = ex.Host
+	t0 := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	got := &Exchange{
+		Remote:         ex.Remote,
+		Start:          t0,
+		Method:         ex.Method,
+		URL:            ex.URL,
+		Host:           ex.Host,
+		Proto:          resp.Proto,
+		RequestHeader:  req.Header,
+		RequestBody:    ex.RequestBody,
+		RequestSize:    int64(len(ex.RequestBody)),
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header,
+	}
+	//the client transparently decompresses when it asked for gzip
+	if resp.Uncompressed {
+		got.ResponseHeader.Del("Content-Encoding")
+	}
+	got.ResponseBody, got.ResponseSize = readBody(resp.Body)
+	got.Duration = time.Since(t0)
+	return got, nil
+}
+
+// Diff compares the status and decoded bodies of the recorded
+// and replayed responses, returning no lines when they match
+func Diff(recorded, replayed *Exchange) []string {
+	var out []string
+	if recorded.Status != replayed.Status {
+		out = append(out, fmt.Sprintf("status: %d => %d", recorded.Status, replayed.Status))
+	}
+	a := DecodeBody(recorded.ResponseHeader, recorded.ResponseBody)
+	b := DecodeBody(replayed.ResponseHeader, replayed.ResponseBody)
+	if a != b {
+		out = append(out, diffLines(strings.Split(a, "\n"), strings.Split(b, "\n"))...)
+	}
+	return out
+}
+
+// maxDiffLines bounds the quadratic line diff
+const maxDiffLines = 2000
+
+// diffLines lists removed (-) and added (+) lines, using
+// the longest common subsequence of the two bodies
+func diffLines(a, b []string) []string {
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return []string{fmt.Sprintf("body: differs (%d => %d lines)", len(a), len(b))}
+	}
+	//lcs[i][j] is the common length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package inspect
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// Exchange is an HTTP request and its response,
+// as seen on a tunneled connection
+type Exchange struct {
+	ID             int64         `json:"id"`
+	Remote         string        `json:"remote"`
+	Start          time.Time     `json:"start"`
+	Duration       time.Duration `json:"duration"`
+	Method         string        `json:"method"`
+	URL            string        `json:"url"`
+	Host           string        `json:"host"`
+	Proto          string        `json:"proto"`
+	RequestHeader  http.Header   `json:"request_header"`
+	RequestBody    []byte        `json:"request_body,omitempty"`
+	RequestSize    int64         `json:"request_size"`
+	Status         int           `json:"status"`
+	ResponseHeader http.Header   `json:"response_header"`
+	ResponseBody   []byte        `json:"response_body,omitempty"`
+	ResponseSize   int64         `json:"response_size"`
+	//Redacted is set when values of the request were hidden
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// Tap wraps the client end of a tunneled connection, whose reads
+// carry requests and whose writes carry responses. The HTTP
+// exchanges flowing through are parsed without altering or
+// delaying the traffic, and each completed exchange is passed
+// to fn. Non-HTTP traffic is simply not reported.
+func Tap(rwc io.ReadWriteCloser, remote string, fn func(*Exchange)) io.ReadWriteCloser {
+	max := settings.EnvInt("INSPECT_MAX_BUFFER", 4<<20)
+	t := &tap{
+		ReadWriteCloser: rwc,
+		reqs:            newFeed(max),
+		resps:           newFeed(max),
+	}
+	pending := make(chan *Exchange, 64)
+	done := make(chan struct{})
+	go t.parseRequests(remote, pending, done)
+	go t.parseResponses(pending, done, fn)
+	return t
+}
+
+type tap struct {
+	io.ReadWriteCloser
+	reqs, resps *feed
+}
+
+func (t *tap) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if n > 0 {
+		t.reqs.Write(p[:n])
+	}
+	if err != nil {
+		t.reqs.Close()
+	}
+	return n, err
+}
+
+func (t *tap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if n > 0 {
+		t.resps.Write(p[:n])
+	}
+	return n, err
+}
+
+func (t *tap) Close() error {
+	t.reqs.Close()
+	t.resps.Close()
+	return t.ReadWriteCloser.Close()
+}
+
+func (t *tap) parseRequests(remote string, pending chan<- *Exchange, done <-chan struct{}) {
+	defer close(pending)
+	defer t.reqs.Close()
+	r := bufio.NewReader(t.reqs)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		ex := &Exchange{
+			Remote:        remote,
+			Start:         time.Now(),
+			Method:        req.Method,
+			URL:           req.RequestURI,
+			Host:          req.Host,
+			Proto:         req.Proto,
+			RequestHeader: req.Header,
+		}
+		ex.RequestBody, ex.RequestSize = readBody(req.Body)
+		select {
+		case pending <- ex:
+		case <-done:
+			return
+		}
+		//upgraded connections no longer carry http
+		if strings.EqualFold(req.Header.Get("Connection"), "upgrade") {
+			return
+		}
+	}
+}
+
+func (t *tap) parseResponses(pending <-chan *Exchange, done chan<- struct{}, fn func(*Exchange)) {
+	defer close(done)
+	defer t.resps.Close()
+	r := bufio.NewReader(t.resps)
+	for ex := range pending {
+		req := &http.Request{Method: ex.Method}
+		resp, err := http.ReadResponse(r, req)
+		//skip informational responses (e.g. 100 Continue)
+		for err == nil && resp.StatusCode >= 100 && resp.StatusCode < 200 &&
+			resp.StatusCode != http.StatusSwitchingProtocols {
+			resp, err = http.ReadResponse(r, req)
+		}
+		if err != nil {
+			return
+		}
+		ex.Status = resp.StatusCode
+		ex.ResponseHeader = resp.Header
+		ex.ResponseBody, ex.ResponseSize = readBody(resp.Body)
+		ex.Duration = time.Since(ex.Start)
+		fn(ex)
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			return
+		}
+	}
+}
+
+// readBody reads a body, keeping up to INSPECT_MAX_BODY bytes
+func readBody(body io.ReadCloser) ([]byte, int64) {
+	defer body.Close()
+	max := int64(settings.EnvInt("INSPECT_MAX_BODY", 1<<20))
+	b, _ := io.ReadAll(io.LimitReader(body, max))
+	rest, _ := io.Copy(io.Discard, body)
+	return b, int64(len(b)) + rest
+}
+
+// feed is a pipe whose writes never wait on its reader,
+// a reader falling too far behind breaks the feed instead
+type feed struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buff   bytes.Buffer
+	max    int
+	closed bool
+}
+
+func newFeed(max int) *feed {
+	f := &feed{max: max}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *feed) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return len(p), nil
+	}
+	if f.buff.Len()+len(p) > f.max {
+		f.closed = true
+	} else {
+		f.buff.Write(p)
+	}
+	f.cond.Broadcast()
+	return len(p), nil
+}
+
+func (f *feed) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.buff.Len() == 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.buff.Len() == 0 {
+		return 0, io.EOF
+	}
+	return f.buff.Read(p)
+}
+
+func (f *feed) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	f.cond.Broadcast()
+	return nil
+}
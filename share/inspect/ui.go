@@ -0,0 +1,87 @@
+package inspect
+
+// uiHTML lists exchanges as they arrive, with a filter
+// bar and a detail pane for the selected exchange
+const uiHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>chissl inspector</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+header { padding: 8px; background: #222; color: #eee; display: flex; gap: 8px; align-items: center; }
+header input, header select { font-size: 13px; }
+main { flex: 1; display: flex; min-height: 0; }
+#list { flex: 1; overflow: auto; border-right: 1px solid #ccc; }
+#detail { flex: 1; overflow: auto; padding: 8px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 3px 6px; white-space: nowrap; }
+tr.row { cursor: pointer; }
+tr.row:hover, tr.selected { background: #eef; }
+.s2 { color: #080; } .s3 { color: #068; } .s4 { color: #b60; } .s5 { color: #c00; }
+pre { background: #f6f6f6; padding: 6px; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<header>
+<b>chissl inspector</b>
+<input id="q" placeholder="search host or url">
+<select id="method"><option value="">any method</option>
+<option>GET</option><option>POST</option><option>PUT</option><option>PATCH</option><option>DELETE</option></select>
+<input id="status" placeholder="status (e.g. 4xx)" size="14">
+<input id="remote" placeholder="remote" size="24">
+<button id="clear">clear</button>
+</header>
+<main>
+<div id="list"><table><thead><tr><th>time</th><th>method</th><th>host</th><th>url</th><th>status</th><th>duration</th><th>size</th></tr></thead><tbody id="rows"></tbody></table></div>
+<div id="detail"></div>
+</main>
+<script>
+var selected = null;
+function $(id) { return document.getElementById(id); }
+function esc(s) { var d = document.createElement("div"); d.textContent = s == null ? "" : String(s); return d.innerHTML; }
+function size(n) { return n < 1024 ? n + "B" : n < 1048576 ? (n / 1024).toFixed(1) + "KB" : (n / 1048576).toFixed(1) + "MB"; }
+function headers(h) { var s = ""; for (var k in h || {}) h[k].forEach(function (v) { s += k + ": " + v + "\n"; }); return s; }
+function query() {
+  var p = new URLSearchParams();
+  ["q", "method", "status", "remote"].forEach(function (k) { if ($(k).value) p.set(k, $(k).value); });
+  return p.toString();
+}
+function refresh() {
+  fetch("api/exchanges?" + query()).then(function (r) { return r.json(); }).then(function (list) {
+    $("rows").innerHTML = list.map(function (e) {
+      return "<tr class='row" + (e.id === selected ? " selected" : "") + "' data-id='" + e.id + "'>" +
+        "<td>" + esc(e.start) + "</td><td>" + esc(e.method) + "</td><td>" + esc(e.host) + "</td>" +
+        "<td>" + esc(e.url) + "</td><td class='s" + String(e.status)[0] + "'>" + e.status + "</td>" +
+        "<td>" + e.duration_ms.toFixed(1) + "ms</td><td>" + size(e.response_size) + "</td></tr>";
+    }).join("");
+  });
+}
+function show(id) {
+  selected = id;
+  fetch("api/exchanges/" + id).then(function (r) { return r.json(); }).then(function (e) {
+    $("detail").innerHTML =
+      "<h3>" + esc(e.method + " " + e.url) + "</h3>" +
+      "<p>" + esc(e.remote) + " &middot; " + esc(e.proto) + " &middot; status " + e.status +
+      " &middot; " + e.duration_ms.toFixed(1) + "ms</p>" +
+      "<h4>Request headers</h4><pre>" + esc(headers(e.request_header)) + "</pre>" +
+      "<h4>Request body (" + size(e.request_size) + ")</h4><pre>" + esc(e.request_body) + "</pre>" +
+      "<h4>Response headers</h4><pre>" + esc(headers(e.response_header)) + "</pre>" +
+      "<h4>Response body (" + size(e.response_size) + ")</h4><pre>" + esc(e.response_body) + "</pre>";
+    refresh();
+  });
+}
+$("rows").onclick = function (ev) {
+  var tr = ev.target.closest("tr");
+  if (tr) show(Number(tr.dataset.id));
+};
+$("clear").onclick = function () {
+  fetch("api/exchanges", { method: "DELETE" }).then(function () { selected = null; $("detail").innerHTML = ""; refresh(); });
+};
+["q", "method", "status", "remote"].forEach(function (k) { $(k).oninput = refresh; });
+refresh();
+setInterval(refresh, 1000);
+</script>
+</body>
+</html>
+`
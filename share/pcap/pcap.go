@@ -0,0 +1,353 @@
+// Package pcap writes the bytes of tunneled connections to
+// pcapng files, framed as synthesized TCP/IPv4 packets, so
+// that captures can be opened with the protocol dissectors
+// of tools like Wireshark
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture splits
+const (
+	SplitRemote  = "remote"
+	SplitSession = "session"
+)
+
+// Capture writes the streams it taps into a directory,
+// with one file per remote or one file per session
+type Capture struct {
+	dir     string
+	split   string
+	mu      sync.Mutex
+	files   map[string]*file
+	streams uint32
+}
+
+// New creates the directory and a Capture writing into it,
+// split is either remote (default) or session
+func New(dir, split string) (*Capture, error) {
+	if split == "" {
+		split = SplitRemote
+	}
+	if split != SplitRemote && split != SplitSession {
+		return nil, fmt.Errorf("unknown capture split '%s'", split)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Capture{dir: dir, split: split, files: map[string]*file{}}, nil
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._=-]+`)
+
+// file returns the open capture file for the given
+// session and remote, creating it on first use
+func (c *Capture) file(session, remote string) (*file, error) {
+	key := remote
+	if c.split == SplitSession {
+		key = session
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.files[key]; ok {
+		return f, nil
+	}
+	name := unsafeName.ReplaceAllString(key, "_") + ".pcapng"
+	f, err := openFile(filepath.Join(c.dir, name), key)
+	if err != nil {
+		return nil, err
+	}
+	c.files[key] = f
+	return f, nil
+}
+
+// EndSession closes the file of a session, once it is over
+func (c *Capture) EndSession(session string) {
+	if c.split != SplitSession {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.files[session]; ok {
+		f.Close()
+		delete(c.files, session)
+	}
+}
+
+// Close closes all capture files
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, f := range c.files {
+		f.Close()
+		delete(c.files, key)
+	}
+	return nil
+}
+
+// Tap wraps the source end of a tunneled connection, whose reads
+// travel towards the target and whose writes travel back. Both
+// directions are written as a TCP stream to the target's port,
+// opened by a SYN packet commented with the remote and label.
+// When the capture fails, rwc is returned as is.
+func (c *Capture) Tap(session, remote, target, label string, rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	f, err := c.file(session, remote)
+	if err != nil {
+		return rwc
+	}
+	n := atomic.AddUint32(&c.streams, 1)
+	port, _ := strconv.Atoi(target)
+	s := &stream{
+		f:       f,
+		client:  endpoint{ip: [4]byte{10, 0, 0, 1}, port: uint16(1024 + n%64000)},
+		server:  endpoint{ip: [4]byte{10, 0, 0, 2}, port: uint16(port)},
+		comment: remote + " " + label,
+	}
+	s.open()
+	return &tap{ReadWriteCloser: rwc, s: s}
+}
+
+type tap struct {
+	io.ReadWriteCloser
+	s    *stream
+	once sync.Once
+}
+
+func (t *tap) Read(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Read(p)
+	if n > 0 {
+		t.s.data(true, p[:n])
+	}
+	return n, err
+}
+
+func (t *tap) Write(p []byte) (int, error) {
+	n, err := t.ReadWriteCloser.Write(p)
+	if n > 0 {
+		t.s.data(false, p[:n])
+	}
+	return n, err
+}
+
+func (t *tap) Close() error {
+	t.once.Do(t.s.close)
+	return t.ReadWriteCloser.Close()
+}
+
+type endpoint struct {
+	ip   [4]byte
+	port uint16
+	seq  uint32
+}
+
+// stream synthesizes the packets of a TCP connection
+type stream struct {
+	f              *file
+	mu             sync.Mutex
+	client, server endpoint
+	comment        string
+}
+
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+	//keeps each packet within the IPv4 total length
+	maxSegment = 65000
+)
+
+func (s *stream) open() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client.seq, s.server.seq = 1000, 5000
+	s.f.packet(s.segment(true, flagSYN, nil), s.comment)
+	s.client.seq++
+	s.f.packet(s.segment(false, flagSYN|flagACK, nil), "")
+	s.server.seq++
+	s.f.packet(s.segment(true, flagACK, nil), "")
+}
+
+func (s *stream) data(fromClient bool, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		s.f.packet(s.segment(fromClient, flagPSH|flagACK, p[:n]), "")
+		if fromClient {
+			s.client.seq += uint32(n)
+		} else {
+			s.server.seq += uint32(n)
+		}
+		p = p[n:]
+	}
+}
+
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.packet(s.segment(true, flagFIN|flagACK, nil), "")
+	s.client.seq++
+	s.f.packet(s.segment(false, flagFIN|flagACK, nil), "")
+	s.server.seq++
+	s.f.packet(s.segment(true, flagACK, nil), "")
+}
+
+// segment builds an IPv4 packet carrying a TCP segment
+func (s *stream) segment(fromClient bool, flags byte, payload []byte) []byte {
+	src, dst := s.client, s.server
+	if !fromClient {
+		src, dst = s.server, s.client
+	}
+	ack := dst.seq
+	if flags&flagACK == 0 {
+		ack = 0
+	}
+	b := make([]byte, 40+len(payload))
+	//ip header
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	b[6] = 0x40 //don't fragment
+	b[8] = 64
+	b[9] = 6 //tcp
+	copy(b[12:16], src.ip[:])
+	copy(b[16:20], dst.ip[:])
+	binary.BigEndian.PutUint16(b[10:], checksum(b[:20], 0))
+	//tcp header
+	t := b[20:]
+	binary.BigEndian.PutUint16(t[0:], src.port)
+	binary.BigEndian.PutUint16(t[2:], dst.port)
+	binary.BigEndian.PutUint32(t[4:], src.seq)
+	binary.BigEndian.PutUint32(t[8:], ack)
+	t[12] = 5 << 4
+	t[13] = flags
+	binary.BigEndian.PutUint16(t[14:], 65535)
+	copy(t[20:], payload)
+	//tcp checksum covers a pseudo header
+	pseudo := uint32(src.ip[0])<<8 | uint32(src.ip[1])
+	pseudo += uint32(src.ip[2])<<8 | uint32(src.ip[3])
+	pseudo += uint32(dst.ip[0])<<8 | uint32(dst.ip[1])
+	pseudo += uint32(dst.ip[2])<<8 | uint32(dst.ip[3])
+	pseudo += 6 + uint32(len(t))
+	binary.BigEndian.PutUint16(t[16:], checksum(t, pseudo))
+	return b
+}
+
+// checksum is the internet checksum (RFC 1071)
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// file is a pcapng file with a single raw IP interface
+type file struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+const (
+	blockSHB    = 0x0A0D0D0A
+	blockIDB    = 0x00000001
+	blockEPB    = 0x00000006
+	linkTypeRaw = 101
+	optComment  = 1
+	optShbApp   = 4
+	optIfName   = 2
+)
+
+// openFile appends a new section to the file at path,
+// pcapng readers handle files holding many sections
+func openFile(path, name string) (*file, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0)) //unknown section length
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], linkTypeRaw)
+	if _, err := f.Write(append(
+		block(blockSHB, shb, options(optShbApp, "chissl")),
+		block(blockIDB, idb, options(optIfName, name))...,
+	)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{f: f}, nil
+}
+
+// packet writes an enhanced packet block, timestamped now
+func (f *file) packet(data []byte, comment string) {
+	us := uint64(time.Now().UnixMicro())
+	body := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(body[4:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(us))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	body = append(body, pad(data)...)
+	var opts []byte
+	if comment != "" {
+		opts = options(optComment, comment)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.f.Write(block(blockEPB, body, opts))
+}
+
+func (f *file) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
+
+// block frames a pcapng block, with its type and lengths
+func block(typ uint32, body, opts []byte) []byte {
+	n := 12 + len(body) + len(opts)
+	b := make([]byte, 8, n)
+	binary.LittleEndian.PutUint32(b[0:], typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(n))
+	b = append(b, body...)
+	b = append(b, opts...)
+	return binary.LittleEndian.AppendUint32(b, uint32(n))
+}
+
+// options encodes a single string option, and the end of options
+func options(code uint16, value string) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b[0:], code)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(value)))
+	b = append(b, pad([]byte(value))...)
+	return append(b, 0, 0, 0, 0)
+}
+
+// pad aligns b to 32 bits
+func pad(b []byte) []byte {
+	if r := len(b) % 4; r != 0 {
+		b = append(b[:len(b):len(b)], make([]byte, 4-r)...)
+	}
+	return b
+}
@@ -0,0 +1,75 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type nopRWC struct {
+	io.Reader
+	io.Writer
+}
+
+func (nopRWC) Close() error { return nil }
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, SplitRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	rwc := c.Tap("session-1", "R:5432=>5432", "5432", "127.0.0.1:40000", nopRWC{bytes.NewReader([]byte("ping")), out})
+	buff := make([]byte, 16)
+	n, _ := rwc.Read(buff)
+	rwc.Write([]byte("pong!"))
+	rwc.Close()
+	c.Close()
+	if n != 4 || out.String() != "pong!" {
+		t.Fatalf("traffic altered")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "R_5432=_5432.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []uint32
+	var payloads []string
+	for len(b) > 0 {
+		typ := binary.LittleEndian.Uint32(b)
+		size := binary.LittleEndian.Uint32(b[4:])
+		if int(size) > len(b) || binary.LittleEndian.Uint32(b[size-4:]) != size {
+			t.Fatalf("invalid block lengths")
+		}
+		types = append(types, typ)
+		if typ == blockEPB {
+			pkt := b[28 : 28+binary.LittleEndian.Uint32(b[20:])]
+			if checksum(pkt[:20], 0) != 0 {
+				t.Fatalf("invalid ip checksum")
+			}
+			if binary.BigEndian.Uint16(pkt[22:]) != 5432 && binary.BigEndian.Uint16(pkt[20:]) != 5432 {
+				t.Fatalf("expected the target port")
+			}
+			if p := pkt[40:]; len(p) > 0 {
+				payloads = append(payloads, string(p))
+			}
+		}
+		b = b[size:]
+	}
+	//section, interface, handshake, 2 segments, close
+	if len(types) != 10 || types[0] != blockSHB || types[1] != blockIDB {
+		t.Fatalf("unexpected blocks %x", types)
+	}
+	if len(payloads) != 2 || payloads[0] != "ping" || payloads[1] != "pong!" {
+		t.Fatalf("unexpected payloads %q", payloads)
+	}
+}
+
+func TestCaptureSplit(t *testing.T) {
+	if _, err := New(t.TempDir(), "conn"); err == nil {
+		t.Fatal("expected error")
+	}
+}
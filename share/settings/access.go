@@ -0,0 +1,126 @@
+package settings
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access protects the public endpoint of an http mode remote,
+// a request is let through when any configured method accepts it
+type Access struct {
+	//BasicAuth holds the "user:pass" expected in
+	//HTTP Basic authorization headers
+	BasicAuth string `json:",omitempty"`
+	//Token is expected as an Authorization bearer token
+	Token string `json:",omitempty"`
+	//SignKey verifies expiring signed URL tokens,
+	//which are exchanged for a cookie
+	SignKey string `json:",omitempty"`
+}
+
+// accessOptions are the remote options configuring Access,
+// whose values are masked in the remote's UserAddress
+var accessOptions = map[string]bool{"basic": true, "token": true, "signed": true}
+
+// AllowBasic checks HTTP Basic credentials
+func (a *Access) AllowBasic(user, pass string) bool {
+	if a.BasicAuth == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(a.BasicAuth)) == 1
+}
+
+// AllowToken checks a bearer token
+func (a *Access) AllowToken(token string) bool {
+	if a.Token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+// AllowSigned checks a signed URL token, returning its expiry
+func (a *Access) AllowSigned(token string, now time.Time) (time.Time, bool) {
+	if a.SignKey == "" {
+		return time.Time{}, false
+	}
+	return VerifyAccessToken(a.SignKey, token, now)
+}
+
+// AccessParam is the query parameter carrying signed tokens
+const AccessParam = "chissl_token"
+
+// SignedURL appends a token, valid until expires, to the
+// url of a remote protected with the given signing key
+func SignedURL(rawURL, key string, expires time.Time) string {
+	sep := "?"
+	if strings.Contains(rawURL, "?") {
+		sep = "&"
+	}
+	return rawURL + sep + AccessParam + "=" + SignAccessToken(key, expires)
+}
+
+// SignAccessToken creates a token, valid until expires,
+// for remotes configured with the given signing key
+func SignAccessToken(key string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + accessSignature(key, exp)
+}
+
+// VerifyAccessToken checks the signature and expiry of a token
+func VerifyAccessToken(key, token string, now time.Time) (time.Time, bool) {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(accessSignature(key, exp))) {
+		return time.Time{}, false
+	}
+	expires := time.Unix(unix, 0)
+	return expires, now.Before(expires)
+}
+
+func accessSignature(key, exp string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte("chissl-access:" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validate checks the configured credentials
+func (a *Access) validate() error {
+	if a.BasicAuth != "" && !strings.Contains(a.BasicAuth, ":") {
+		return errors.New("basic credentials must be in the form user:pass")
+	}
+	if a.Token != "" && len(a.Token) < 16 {
+		return errors.New("tokens must be at least 16 characters")
+	}
+	if a.SignKey != "" && len(a.SignKey) < 16 {
+		return errors.New("signing keys must be at least 16 characters")
+	}
+	return nil
+}
+
+// maskAccess hides the credentials held in the options of
+// a remote, so that they're not written to the server logs
+func maskAccess(s string) string {
+	head, query, found := strings.Cut(s, "?")
+	if !found {
+		return s
+	}
+	pairs := strings.Split(query, "&")
+	for i, p := range pairs {
+		if k, _, ok := strings.Cut(p, "="); ok && accessOptions[k] {
+			pairs[i] = k + "=***"
+		}
+	}
+	return head + "?" + strings.Join(pairs, "&")
+}
@@ -0,0 +1,26 @@
+package settings
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessToken(t *testing.T) {
+	now := time.Now()
+	token := SignAccessToken("0123456789abcdef", now.Add(time.Hour))
+	a := &Access{SignKey: "0123456789abcdef"}
+	if _, ok := a.AllowSigned(token, now); !ok {
+		t.Fatal("expected valid token")
+	}
+	if _, ok := a.AllowSigned(token, now.Add(2*time.Hour)); ok {
+		t.Fatal("expected expired token")
+	}
+	if _, ok := (&Access{SignKey: "fedcba9876543210"}).AllowSigned(token, now); ok {
+		t.Fatal("expected token signed by another key to be rejected")
+	}
+	exp, _, _ := strings.Cut(token, ".")
+	if _, ok := a.AllowSigned(exp+"0."+token[len(exp)+1:], now); ok {
+		t.Fatal("expected tampered token to be rejected")
+	}
+}
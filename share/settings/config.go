@@ -8,6 +8,9 @@ import (
 type Config struct {
 	Version string
 	Remotes
+	//OpenVersion is the newest channel open payload
+	//understood by the client, zero for bare remotes
+	OpenVersion int `json:",omitempty"`
 }
 
 func DecodeConfig(b []byte) (*Config, error) {
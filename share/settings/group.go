@@ -0,0 +1,40 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+)
+
+// Group holds the roles and access rules shared by its members
+type Group struct {
+	Name       string           `json:"name"`
+	Roles      []string         `json:"roles,omitempty"`
+	Addrs      []*regexp.Regexp `json:"addresses,omitempty"`
+	AllowSocks bool             `json:"allow_socks,omitempty"`
+	Allow      []string         `json:"allow,omitempty"`
+	Deny       []string         `json:"deny,omitempty"`
+}
+
+// ValidateGroup validates the fields of the Group struct
+func (g *Group) ValidateGroup() error {
+	if g.Name == "" {
+		return errors.New("group name must not be empty")
+	}
+	for _, r := range g.Name {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-' && r != '_' {
+			return errors.New("group name must be alphanumeric, dashes and underscores")
+		}
+	}
+	if err := validateRoles(g.Roles); err != nil {
+		return err
+	}
+	if _, err := ParseCIDRs(g.Allow); err != nil {
+		return fmt.Errorf("invalid allow list: %v", err)
+	}
+	if _, err := ParseCIDRs(g.Deny); err != nil {
+		return fmt.Errorf("invalid deny list: %v", err)
+	}
+	return nil
+}
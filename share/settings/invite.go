@@ -0,0 +1,101 @@
+package settings
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Invite describes the user created when an invitation
+// token is redeemed, and where to redeem it. The server
+// signs invites, so that tokens need no server side state,
+// and each can only be redeemed once, as it names the user.
+type Invite struct {
+	Server      string     `json:"server"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	Addrs       []string   `json:"addresses"`
+	AllowSocks  bool       `json:"allow_socks,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	//RedeemBy is when the token itself expires
+	RedeemBy time.Time `json:"redeem_by"`
+	//Nonce identifies the token, which is
+	//recorded when redeemed so it's single use
+	Nonce string `json:"nonce"`
+}
+
+// InviteRedemption holds the credentials of a redeemed invite
+type InviteRedemption struct {
+	Username    string     `json:"username"`
+	Password    string     `json:"password"`
+	Fingerprint string     `json:"fingerprint"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// SignInvite encodes an invite into a token signed with key
+func SignInvite(key []byte, inv *Invite) (string, error) {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + inviteSignature(key, payload), nil
+}
+
+// ParseInvite decodes a token without verifying it, so
+// that clients can find the server to redeem it with
+func ParseInvite(token string) (*Invite, error) {
+	payload, _, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return nil, errors.New("malformed invitation token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New("malformed invitation token")
+	}
+	inv := &Invite{}
+	if err := json.Unmarshal(b, inv); err != nil {
+		return nil, errors.New("malformed invitation token")
+	}
+	return inv, nil
+}
+
+// VerifyInvite checks the signature and expiry of a token
+func VerifyInvite(key []byte, token string, now time.Time) (*Invite, error) {
+	token = strings.TrimSpace(token)
+	payload, sig, _ := strings.Cut(token, ".")
+	if !hmac.Equal([]byte(sig), []byte(inviteSignature(key, payload))) {
+		return nil, errors.New("invalid invitation token")
+	}
+	inv, err := ParseInvite(token)
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(inv.RedeemBy) {
+		return nil, errors.New("invitation token expired")
+	}
+	return inv, nil
+}
+
+func inviteSignature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("chissl-invite:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseTTL parses a duration, which may also be given in days (e.g. 7d)
+func ParseTTL(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid duration: " + s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,53 @@
+package settings
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvite(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	now := time.Now()
+	token, err := SignInvite(key, &Invite{
+		Server:   "https://example.com",
+		Username: "guest",
+		Addrs:    []string{"^9001$"},
+		RedeemBy: now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := ParseInvite(token)
+	if err != nil || inv.Server != "https://example.com" {
+		t.Fatalf("expected to parse the invite, got %v %v", inv, err)
+	}
+	if inv, err = VerifyInvite(key, token, now); err != nil || inv.Username != "guest" {
+		t.Fatalf("expected a valid invite, got %v %v", inv, err)
+	}
+	if _, err := VerifyInvite(key, token, now.Add(2*time.Hour)); err == nil {
+		t.Fatalf("expected an expired invite")
+	}
+	if _, err := VerifyInvite([]byte("another key 1234"), token, now); err == nil {
+		t.Fatalf("expected an invalid signature")
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	if _, err := VerifyInvite(key, payload+"x."+sig, now); err == nil {
+		t.Fatalf("expected a tampered invite to be rejected")
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"7d":   7 * 24 * time.Hour,
+		"36h":  36 * time.Hour,
+		"0.5d": 12 * time.Hour,
+	} {
+		if got, err := ParseTTL(s); err != nil || got != want {
+			t.Fatalf("%s: expected %s, got %s %v", s, want, got, err)
+		}
+	}
+	if _, err := ParseTTL("xd"); err == nil {
+		t.Fatalf("expected an invalid duration")
+	}
+}
@@ -0,0 +1,20 @@
+package settings
+
+import "time"
+
+// Lockout kinds
+const (
+	LockoutIP   = "ip"
+	LockoutUser = "user"
+)
+
+// LockoutEntry describes the failed logins of an ip, which is
+// locked out until Until (when set), or of a username, whose
+// failed logins are delayed the longest until then
+type LockoutEntry struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Failures int       `json:"failures"`
+	Last     time.Time `json:"last_failure"`
+	Until    time.Time `json:"locked_until,omitempty"`
+}
@@ -0,0 +1,149 @@
+package settings
+
+import (
+	"fmt"
+	"net"
+)
+
+// Roles grant permissions on the REST API
+const (
+	RoleAdmin       = "admin"
+	RoleUserManager = "user-manager"
+	RoleViewer      = "viewer"
+	RoleTunnelOnly  = "tunnel-only"
+)
+
+// Permissions checked by REST endpoints
+const (
+	PermUsersRead     = "users:read"
+	PermUsersWrite    = "users:write"
+	PermUsersGrant    = "users:grant"
+	PermLockoutsRead  = "lockouts:read"
+	PermLockoutsWrite = "lockouts:write"
+	PermSessionsRead  = "sessions:read"
+	PermSessionsKick  = "sessions:kick"
+)
+
+// RolePermissions lists the permissions of each role. Admins
+// hold every permission, users without roles hold none and
+// may only open tunnels, just like tunnel-only users.
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermUsersRead, PermUsersWrite, PermUsersGrant,
+		PermLockoutsRead, PermLockoutsWrite,
+		PermSessionsRead, PermSessionsKick,
+	},
+	RoleUserManager: {
+		PermUsersRead, PermUsersWrite,
+		PermLockoutsRead, PermLockoutsWrite,
+		PermSessionsRead, PermSessionsKick,
+	},
+	RoleViewer: {
+		PermUsersRead, PermLockoutsRead, PermSessionsRead,
+	},
+	RoleTunnelOnly: {},
+}
+
+func validateRoles(roles []string) error {
+	for _, r := range roles {
+		if _, ok := RolePermissions[r]; !ok {
+			return fmt.Errorf("unknown role '%s'", r)
+		}
+	}
+	return nil
+}
+
+// Permissions returns the permissions granted by the roles
+// of the user, IsAdmin being a shorthand for the admin role.
+// Roles inherited from groups are only included once the user
+// has been resolved with Users.Resolve.
+func (u *User) Permissions() map[string]bool {
+	perms := map[string]bool{}
+	roles := u.Roles
+	if u.IsAdmin {
+		roles = append([]string{RoleAdmin}, roles...)
+	}
+	for _, r := range roles {
+		for _, p := range RolePermissions[r] {
+			perms[p] = true
+		}
+	}
+	return perms
+}
+
+// Can checks whether the user holds a permission
+func (u *User) Can(perm string) bool {
+	return u.Permissions()[perm]
+}
+
+// CanManage checks whether the user may create, change or delete
+// the target user: either it may grant any permission, or it
+// holds every permission and all the tunnel access of the target
+func (u *User) CanManage(target *User) bool {
+	perms := u.Permissions()
+	if perms[PermUsersGrant] {
+		return true
+	}
+	for p := range target.Permissions() {
+		if !perms[p] {
+			return false
+		}
+	}
+	return u.coversAccess(target)
+}
+
+// coversAccess checks that the tunnel access of the target is
+// within the user's: the same addresses (or the user may reach
+// any), socks only if the user has it, allow lists within the
+// user's, and deny lists which still exclude what the user's do
+func (u *User) coversAccess(target *User) bool {
+	if target.AllowSocks && !u.AllowSocks {
+		return false
+	}
+	addrs := map[string]bool{}
+	for _, re := range u.Addrs {
+		addrs[re.String()] = true
+	}
+	if !addrs[UserAllowAll.String()] && !addrs[""] {
+		for _, re := range target.Addrs {
+			if !addrs[re.String()] {
+				return false
+			}
+		}
+	}
+	allow, err1 := ParseCIDRs(u.Allow)
+	deny, err2 := ParseCIDRs(u.Deny)
+	targetAllow, err3 := ParseCIDRs(target.Allow)
+	targetDeny, err4 := ParseCIDRs(target.Deny)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return false
+	}
+	if len(allow) > 0 {
+		if len(targetAllow) == 0 {
+			return false
+		}
+		for _, n := range targetAllow {
+			if !anyContains(allow, n) {
+				return false
+			}
+		}
+	}
+	for _, n := range deny {
+		if !anyContains(targetDeny, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyContains checks whether one of nets contains all of n
+func anyContains(nets []*net.IPNet, n *net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	for _, c := range nets {
+		cones, cbits := c.Mask.Size()
+		if cbits == bits && cones <= ones && c.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package settings
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	a, err := DecodeAuthFile([]byte(`{
+		"groups": [
+			{"name":"helpdesk","roles":["user-manager"],"addresses":["^80"],"deny":["10.0.0.0/8"]}
+		],
+		"users": [
+			{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true},
+			{"username":"help","password":"help1234","groups":["helpdesk"]},
+			{"username":"view","password":"view1234","addresses":[".*"],"roles":["viewer"]},
+			{"username":"tun","password":"tunn1234","addresses":[".*"]},
+			{"username":"web","password":"webb1234","addresses":["^80"],"deny":["10.1.0.0/16","10.0.0.0/8"]},
+			{"username":"open","password":"open1234","addresses":["^80"]},
+			{"username":"socks","password":"sock1234","addresses":["^80"],"deny":["10.0.0.0/8"],"allow_socks":true}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	users := NewUsers()
+	users.ResetGroups(a.Groups)
+	users.Reset(a.Users)
+	get := func(name string) *User {
+		u, _ := users.Get(name)
+		return users.Resolve(u)
+	}
+	root, help, view, tun := get("root"), get("help"), get("view"), get("tun")
+
+	if !help.Can(PermUsersWrite) || help.Can(PermUsersGrant) || !help.HasAccess("8080") || len(help.Deny) != 1 {
+		t.Fatalf("expected help to inherit the helpdesk group, got %+v", help)
+	}
+	if !view.Can(PermUsersRead) || view.Can(PermUsersWrite) || len(tun.Permissions()) != 0 {
+		t.Fatalf("unexpected viewer or tunnel permissions")
+	}
+	if !root.CanManage(help) || !root.CanManage(tun) || !help.CanManage(get("web")) {
+		t.Fatalf("expected users to manage users holding fewer permissions")
+	}
+	if help.CanManage(root) || view.CanManage(help) {
+		t.Fatalf("expected users not to manage users holding more permissions")
+	}
+	//nor users with wider tunnel access
+	for _, name := range []string{"view", "tun", "open", "socks"} {
+		if help.CanManage(get(name)) {
+			t.Fatalf("expected help not to manage %s, whose access is wider", name)
+		}
+	}
+}
+
+func TestAuthFileValidate(t *testing.T) {
+	a, err := DecodeAuthFile([]byte(`[{"username":"foo","password":"bar12345","groups":["missing"]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Validate(); err == nil || !strings.Contains(err.Error(), "unknown group") {
+		t.Fatalf("expected an unknown group, got %v", err)
+	}
+	a, _ = DecodeAuthFile([]byte(`[{"username":"foo","password":"bar12345","addresses":[".*"],"roles":["root"]}]`))
+	if err := a.Validate(); err == nil || !strings.Contains(err.Error(), "unknown role") {
+		t.Fatalf("expected an unknown role, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,10 +14,59 @@ import (
 type Remote struct {
 	UserAddress            string
 	LocalHost, LocalPort   string
+	LocalProto             string
 	RemoteHost, RemotePort string
+	RemoteProto            string
 	Reverse                bool
+	Socks                  bool
+	//Share names a private service, published by
+	//one client and consumed by others via the server
+	Share string
+	//LocalSocket and RemoteSocket replace host and
+	//port with the path of a unix domain socket
+	LocalSocket, RemoteSocket string
+	//TLS selects how the server handles TLS on a reverse
+	//remote, TLSPassthrough forwards the raw stream instead
+	//of terminating it with the server's certificate
+	TLS string
+	//ServerName routes passthrough connections by SNI,
+	//wildcards (*.example.com) and empty catch-alls are allowed
+	ServerName string
+	//CertFile and KeyFile let the client terminate
+	//passthrough connections with its own certificate
+	CertFile string `json:"-"`
+	KeyFile  string `json:"-"`
+	//ALPN lists the protocols offered by the server's tls
+	//listener, defaulting to http/1.1, h2 enables HTTP/2
+	ALPN []string
+	//Upstream makes the client re-encrypt connections
+	//to the target, either UpstreamTLS or UpstreamTLSInsecure
+	Upstream string `json:"-"`
+	//HTTP makes the server proxy requests rather than bytes,
+	//adding X-Forwarded-* headers and logging each request
+	HTTP bool
+	//ProxyProtocol makes the client send a PROXY protocol
+	//header (v1 or v2) with the public peer address
+	ProxyProtocol string
+	//Access protects the public endpoint of an http
+	//mode remote, enforced by the server
+	Access *Access `json:",omitempty"`
+	//Allow and Deny restrict the peers which may connect
+	//to the remote's listener, in CIDR notation
+	Allow []string `json:",omitempty"`
+	Deny  []string `json:",omitempty"`
 }
 
+// TLSPassthrough routes TLS connections by SNI,
+// without decrypting them on the server
+const TLSPassthrough = "passthrough"
+
+// Upstream modes, the latter skips certificate verification
+const (
+	UpstreamTLS         = "tls"
+	UpstreamTLSInsecure = "tls-insecure"
+)
+
 func validatePorts(port string) (int, error) {
 	p, err := strconv.Atoi(port)
 	if err != nil || p < 1 || p > 65535 {
@@ -41,53 +92,283 @@ func validateHost(host string) error {
 	return nil
 }
 
+var (
+	shareNameRe = regexp.MustCompile(`^[a-zA-Z0-9][\w.-]*$`)
+	portHostRe  = regexp.MustCompile(`^(\d+)(?::([\w.-]+))?$`)
+	hostPortRe  = regexp.MustCompile(`^([\w.-]*[a-zA-Z_.-][\w.-]*):(\d+)$`)
+)
+
+// splitHostPort decodes the port[:host] portion of a remote,
+// host:port is also accepted since a host is never just digits
+func splitHostPort(s, defaultHost string) (host, port string, err error) {
+	if parts := portHostRe.FindStringSubmatch(s); len(parts) == 3 {
+		port, host = parts[1], parts[2]
+	} else if parts := hostPortRe.FindStringSubmatch(s); len(parts) == 3 {
+		host, port = parts[1], parts[2]
+	} else {
+		return "", "", errors.New("invalid format")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	if _, err := validatePorts(port); err != nil {
+		return "", "", err
+	}
+	if err := validateHost(host); err != nil {
+		return "", "", err
+	}
+	return host, port, nil
+}
+
 func DecodeRemote(s string) (*Remote, error) {
-	parts := regexp.MustCompile(`^\s*(\d+)(?::([\w.-]+))?\s*->\s*(\d+)(?::([\w.-]+))?\s*$`).FindStringSubmatch(s)
-	if len(parts) != 5 {
+	//extract the optional options suffix (e.g. 443->8443?tls=passthrough)
+	head, query, _ := strings.Cut(strings.TrimSpace(s), "?")
+	//extract the optional layer-4 protocol suffix (e.g. 5353->53/udp)
+	head, proto := L4Proto(head)
+	proto = strings.ToLower(proto)
+	if proto == "" {
+		proto = "tcp"
+	}
+	//forward remotes listen on the client and dial from the server
+	reverse := true
+	if strings.HasPrefix(head, "L:") {
+		head = head[2:]
+		reverse = false
+	}
+	sides := strings.SplitN(head, "->", 2)
+	if len(sides) != 2 {
 		return nil, errors.New("invalid remote format" + s)
 	}
+	local := strings.TrimSpace(sides[0])
+	remote := strings.TrimSpace(sides[1])
 
-	// Local
-	localPort := parts[1]
-	localHost := parts[2]
-	if localHost == "" {
-		localHost = "0.0.0.0"
+	var err error
+	r := &Remote{
+		UserAddress: maskAccess(strings.TrimSpace(s)),
+		LocalProto:  proto,
+		RemoteProto: proto,
+		Reverse:     reverse,
 	}
 
-	// Remote
-	remotePort := parts[3]
-	remoteHost := parts[4]
-	if remoteHost == "" {
-		remoteHost = "127.0.0.1"
+	// Unix domain sockets, on either side
+	if path, ok := strings.CutPrefix(local, "unix:"); ok {
+		if r.LocalSocket, err = validateSocket(path, proto); err != nil {
+			return nil, fmt.Errorf("invalid local socket: %v", err)
+		}
+	}
+	if path, ok := strings.CutPrefix(remote, "unix:"); ok {
+		if r.RemoteSocket, err = validateSocket(path, proto); err != nil {
+			return nil, fmt.Errorf("invalid remote socket: %v", err)
+		}
 	}
 
-	// Validate ports
-	if _, err := validatePorts(remotePort); err != nil {
-		return nil, fmt.Errorf("invalid remote port: %v", err)
+	// Shared services are consumed by listening on the client
+	if strings.HasPrefix(remote, "share:") {
+		r.Reverse = false
 	}
-	if _, err := validatePorts(localPort); err != nil {
-		return nil, fmt.Errorf("invalid local port: %v", err)
+
+	// Local, forward remotes default to the loopback
+	// interface to avoid exposing the server's network,
+	// as do socks remotes, which would otherwise open the
+	// client's network to anyone who can reach the server
+	localHost := "0.0.0.0"
+	if !r.Reverse || remote == "socks" {
+		localHost = "127.0.0.1"
+	}
+	if name, ok := strings.CutPrefix(local, "share:"); ok {
+		//the publisher's side of a share has no listener
+		if !r.Reverse {
+			return nil, errors.New("shares must be published with a reverse remote")
+		}
+		if !shareNameRe.MatchString(name) {
+			return nil, errors.New("invalid share name")
+		}
+		r.Share = name
+		r.LocalHost = "0.0.0.0"
+	} else if r.LocalSocket == "" {
+		if r.LocalHost, r.LocalPort, err = splitHostPort(local, localHost); err != nil {
+			return nil, fmt.Errorf("invalid local address: %v", err)
+		}
 	}
 
-	// Validate remote host
-	if err := validateHost(remoteHost); err != nil {
-		return nil, fmt.Errorf("invalid remote host: %v", err)
+	// Remote
+	if name, ok := strings.CutPrefix(remote, "share:"); ok {
+		if r.Share != "" || !shareNameRe.MatchString(name) {
+			return nil, errors.New("invalid share name")
+		}
+		r.Share = name
+		return r, nil
+	}
+	if remote == "socks" {
+		if proto == "udp" {
+			return nil, errors.New("socks remotes only support tcp")
+		}
+		if !reverse || r.Share != "" {
+			return nil, errors.New("socks remotes cannot be forwarded or shared")
+		}
+		r.Socks = true
+		return r, nil
+	}
+	if r.RemoteSocket == "" {
+		if r.RemoteHost, r.RemotePort, err = splitHostPort(remote, "127.0.0.1"); err != nil {
+			return nil, fmt.Errorf("invalid remote address: %v", err)
+		}
 	}
+	if err := r.decodeOptions(query); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
 
-	// Validate local host
-	if err := validateHost(localHost); err != nil {
-		return nil, fmt.Errorf("invalid local host: %v", err)
+// decodeOptions applies the query string of a remote
+func (r *Remote) decodeOptions(query string) error {
+	if query == "" {
+		return nil
+	}
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("invalid options: %v", err)
+	}
+	for k, v := range opts {
+		val := v[len(v)-1]
+		switch k {
+		case "tls":
+			if val != TLSPassthrough {
+				return fmt.Errorf("invalid tls mode '%s'", val)
+			}
+			r.TLS = val
+		case "sni":
+			r.ServerName = strings.ToLower(val)
+		case "cert":
+			r.CertFile = val
+		case "key":
+			r.KeyFile = val
+		case "alpn":
+			for _, p := range strings.Split(val, ",") {
+				if p != "h2" && p != "http/1.1" {
+					return fmt.Errorf("unsupported alpn protocol '%s'", p)
+				}
+				r.ALPN = append(r.ALPN, p)
+			}
+		case "mode":
+			if val != "http" {
+				return fmt.Errorf("invalid mode '%s'", val)
+			}
+			r.HTTP = true
+		case "proxy":
+			if val != "v1" && val != "v2" {
+				return fmt.Errorf("invalid proxy protocol version '%s'", val)
+			}
+			r.ProxyProtocol = val
+		case "basic", "token", "signed":
+			if r.Access == nil {
+				r.Access = &Access{}
+			}
+			switch k {
+			case "basic":
+				r.Access.BasicAuth = val
+			case "token":
+				r.Access.Token = val
+			case "signed":
+				r.Access.SignKey = val
+			}
+		case "allow", "deny":
+			list := strings.Split(val, ",")
+			if _, err := ParseCIDRs(list); err != nil {
+				return fmt.Errorf("invalid %s list: %v", k, err)
+			}
+			if k == "allow" {
+				r.Allow = list
+			} else {
+				r.Deny = list
+			}
+		case "upstream":
+			if val != UpstreamTLS && val != UpstreamTLSInsecure {
+				return fmt.Errorf("invalid upstream mode '%s'", val)
+			}
+			r.Upstream = val
+		default:
+			return fmt.Errorf("unknown option '%s'", k)
+		}
+	}
+	if (r.Allow != nil || r.Deny != nil) && (r.IsUDP() || r.Publishes()) {
+		return errors.New("allow and deny require a tcp listener")
+	}
+	reverseTCP := !r.IsUDP() && !r.Socks && !r.Publishes() && r.Reverse
+	if (r.ALPN != nil || r.Upstream != "" || r.HTTP || r.ProxyProtocol != "") && !reverseTCP {
+		return errors.New("alpn, upstream, proxy and http mode require a reverse tcp remote")
+	}
+	if r.HTTP && r.ProxyProtocol != "" {
+		return errors.New("http mode forwards the peer address in headers, not the proxy protocol")
+	}
+	if r.Access != nil {
+		if !r.HTTP {
+			return errors.New("basic, token and signed access require http mode")
+		}
+		if err := r.Access.validate(); err != nil {
+			return err
+		}
 	}
+	if r.TLS != TLSPassthrough {
+		if r.ServerName != "" || r.CertFile != "" || r.KeyFile != "" {
+			return errors.New("sni, cert and key require tls=passthrough")
+		}
+		return nil
+	}
+	if !reverseTCP {
+		return errors.New("tls passthrough requires a reverse tcp remote")
+	}
+	if r.ALPN != nil || r.HTTP {
+		return errors.New("passthrough remotes cannot use alpn or http mode")
+	}
+	if (r.CertFile == "") != (r.KeyFile == "") {
+		return errors.New("cert and key must be set together")
+	}
+	if strings.ContainsAny(strings.TrimPrefix(r.ServerName, "*."), "*/:") {
+		return errors.New("invalid sni")
+	}
+	return nil
+}
 
-	r := &Remote{
-		UserAddress: strings.TrimSpace(s),
-		LocalHost:   localHost,
-		LocalPort:   localPort,
-		RemoteHost:  remoteHost,
-		RemotePort:  remotePort,
-		Reverse:     true,
+// options encodes the server-side options of a remote
+func (r Remote) options() string {
+	opts := []string{}
+	if r.TLS != "" {
+		opts = append(opts, "tls="+r.TLS)
 	}
-	return r, nil
+	if r.ServerName != "" {
+		opts = append(opts, "sni="+r.ServerName)
+	}
+	if r.ALPN != nil {
+		opts = append(opts, "alpn="+strings.Join(r.ALPN, ","))
+	}
+	if r.HTTP {
+		opts = append(opts, "mode=http")
+	}
+	if r.ProxyProtocol != "" {
+		opts = append(opts, "proxy="+r.ProxyProtocol)
+	}
+	if r.Allow != nil {
+		opts = append(opts, "allow="+strings.Join(r.Allow, ","))
+	}
+	if r.Deny != nil {
+		opts = append(opts, "deny="+strings.Join(r.Deny, ","))
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	return "?" + strings.Join(opts, "&")
+}
+
+// validateSocket checks the path of a unix domain socket
+func validateSocket(path, proto string) (string, error) {
+	if proto != "tcp" {
+		return "", errors.New("unix sockets only support tcp")
+	}
+	if path == "" || strings.ContainsAny(path, "\x00") {
+		return "", errors.New("invalid path")
+	}
+	return path, nil
 }
 
 var l4Proto = regexp.MustCompile(`(?i)\/(tcp|udp)$`)
@@ -104,33 +385,145 @@ func L4Proto(s string) (head, proto string) {
 // implement Stringer
 func (r Remote) String() string {
 	sb := strings.Builder{}
+	if !r.Reverse {
+		sb.WriteString("L:")
+	}
 	sb.WriteString(strings.TrimPrefix(r.Local(), "0.0.0.0:"))
 	sb.WriteString("->")
 	sb.WriteString(strings.TrimPrefix(r.Remote(), "127.0.0.1:"))
+	sb.WriteString(r.options())
 	return sb.String()
 }
 
+// IsUDP is true when the remote forwards datagrams
+func (r Remote) IsUDP() bool {
+	return r.LocalProto == "udp"
+}
+
 // Encode remote to a string
 func (r Remote) Encode() string {
-	return r.Local() + "->" + r.Remote()
+	if !r.Reverse {
+		return "L:" + r.Local() + "->" + r.Remote() + r.options()
+	}
+	return r.Local() + "->" + r.Remote() + r.options()
 }
 
 // Local is the decodable local portion
-func (r Remote) Local() string { return r.LocalHost + ":" + r.LocalPort }
+func (r Remote) Local() string {
+	if r.Publishes() {
+		return "share:" + r.Share
+	}
+	if r.LocalSocket != "" {
+		return "unix:" + r.LocalSocket
+	}
+	return r.LocalHost + ":" + r.LocalPort
+}
 
-// Remote is the decodable remote portion
+// Publishes is true when this remote publishes a share,
+// rather than listening on a port
+func (r Remote) Publishes() bool {
+	return r.Share != "" && r.Reverse
+}
+
+// Remote is the decodable remote portion,
+// udp remotes carry a /udp suffix
 func (r Remote) Remote() string {
+	if r.Socks {
+		return "socks"
+	}
+	if r.Share != "" && !r.Reverse {
+		return "share:" + r.Share
+	}
+	if r.RemoteSocket != "" {
+		return "unix:" + r.RemoteSocket
+	}
+	if r.RemoteProto == "udp" {
+		return r.RemoteHost + ":" + r.RemotePort + "/udp"
+	}
 	return r.RemoteHost + ":" + r.RemotePort
 }
 
-// UserAddr is checked when checking if a
-// user has access to a given remote
+// ServerSocket is the unix socket the server would listen
+// on or dial for this remote, if any
+func (r Remote) ServerSocket() string {
+	if r.Reverse {
+		return r.LocalSocket
+	}
+	return r.RemoteSocket
+}
+
+// UserAddr is checked when checking if a user has access to a
+// given remote. It is built from the remote's fields, in the
+// [L:]local-port:local-host->remote-port:remote-host form with
+// defaults filled in, rather than taken from what the client wrote.
+// Shares are keyed by name alone, share:<name>-> to publish and
+// ->share:<name> to consume, so port rules never grant a share.
 func (r Remote) UserAddr() string {
-	return r.UserAddress
+	if r.Publishes() {
+		return "share:" + r.Share + "->"
+	} else if r.Share != "" {
+		return "->share:" + r.Share
+	}
+	local := r.LocalPort + ":" + r.LocalHost
+	if r.LocalSocket != "" {
+		local = "unix:" + r.LocalSocket
+	}
+	remote := r.RemotePort + ":" + r.RemoteHost
+	if r.Socks {
+		remote = "socks"
+	} else if r.RemoteSocket != "" {
+		remote = "unix:" + r.RemoteSocket
+	}
+	addr := local + "->" + remote
+	if !r.Reverse {
+		addr = "L:" + addr
+	}
+	if r.IsUDP() {
+		addr += "/udp"
+	}
+	return addr + r.options()
+}
+
+// Verify checks that a remote received from a client is one
+// DecodeRemote would produce, since the server trusts its fields
+func (r *Remote) Verify() error {
+	c, err := DecodeRemote(r.Encode())
+	if err != nil {
+		return err
+	}
+	//access options aren't encoded, to keep them out of logs
+	c.UserAddress, c.Access = r.UserAddress, r.Access
+	if !reflect.DeepEqual(c, r) {
+		return errors.New("inconsistent remote")
+	}
+	if r.Access != nil {
+		if !r.HTTP {
+			return errors.New("basic, token and signed access require http mode")
+		}
+		return r.Access.validate()
+	}
+	return nil
 }
 
 // CanListen checks if the port can be listened on
 func (r Remote) CanListen() bool {
+	if r.IsUDP() {
+		conn, err := net.ListenPacket("udp", r.Local())
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		return false
+	}
+	if r.LocalSocket != "" {
+		//closing the listener removes the socket file
+		conn, err := net.Listen("unix", r.LocalSocket)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		return false
+	}
 	conn, err := net.Listen("tcp", r.Local())
 	if err == nil {
 		conn.Close()
@@ -141,9 +534,16 @@ func (r Remote) CanListen() bool {
 
 type Remotes []*Remote
 
-// TODO: Legacy to be removed
+// Reversed filters remotes by direction, reverse remotes
+// listen on the server and forward remotes on the client
 func (rs Remotes) Reversed(reverse bool) Remotes {
-	return rs
+	subset := Remotes{}
+	for _, r := range rs {
+		if r.Reverse == reverse {
+			subset = append(subset, r)
+		}
+	}
+	return subset
 }
 
 // Encode back into strings
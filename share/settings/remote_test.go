@@ -2,6 +2,7 @@ package settings
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 )
 
@@ -21,6 +22,8 @@ func TestRemoteDecode(t *testing.T) {
 				LocalHost:   "0.0.0.0",
 				LocalPort:   "8080",
 				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
 			},
 			"0.0.0.0:8080->127.0.0.1:80",
 		},
@@ -33,6 +36,8 @@ func TestRemoteDecode(t *testing.T) {
 				RemoteHost:  "remotehost",
 				RemotePort:  "8080",
 				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
 			},
 			"localhost:80->remotehost:8080",
 		},
@@ -45,6 +50,8 @@ func TestRemoteDecode(t *testing.T) {
 				RemoteHost:  "localhost",
 				RemotePort:  "8080",
 				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
 			},
 			"10.1.2.3:80->localhost:8080",
 		},
@@ -57,6 +64,8 @@ func TestRemoteDecode(t *testing.T) {
 				RemoteHost:  "localhost",
 				RemotePort:  "80",
 				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
 			},
 			"0.0.0.0:8080->localhost:80",
 		},
@@ -69,9 +78,167 @@ func TestRemoteDecode(t *testing.T) {
 				RemoteHost:  "127.0.0.1",
 				RemotePort:  "80",
 				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
 			},
 			"127.0.0.1:8080->127.0.0.1:80",
 		},
+		{
+			"5353->53/udp",
+			Remote{
+				UserAddress: "5353->53/udp",
+				LocalHost:   "0.0.0.0",
+				LocalPort:   "5353",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "53",
+				Reverse:     true,
+				LocalProto:  "udp",
+				RemoteProto: "udp",
+			},
+			"0.0.0.0:5353->127.0.0.1:53/udp",
+		},
+		{
+			"1080->socks",
+			Remote{
+				UserAddress: "1080->socks",
+				LocalHost:   "127.0.0.1",
+				LocalPort:   "1080",
+				Reverse:     true,
+				Socks:       true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+			},
+			"127.0.0.1:1080->socks",
+		},
+		{
+			"L:5432->db.internal:5432",
+			Remote{
+				UserAddress: "L:5432->db.internal:5432",
+				LocalHost:   "127.0.0.1",
+				LocalPort:   "5432",
+				RemoteHost:  "db.internal",
+				RemotePort:  "5432",
+				Reverse:     false,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+			},
+			"L:127.0.0.1:5432->db.internal:5432",
+		},
+		{
+			"share:pg-alice->5432",
+			Remote{
+				UserAddress: "share:pg-alice->5432",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "5432",
+				Reverse:     true,
+				Share:       "pg-alice",
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+			},
+			"share:pg-alice->127.0.0.1:5432",
+		},
+		{
+			"5433->share:pg-alice",
+			Remote{
+				UserAddress: "5433->share:pg-alice",
+				LocalHost:   "127.0.0.1",
+				LocalPort:   "5433",
+				Reverse:     false,
+				Share:       "pg-alice",
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+			},
+			"L:127.0.0.1:5433->share:pg-alice",
+		},
+		{
+			"2375->unix:/var/run/docker.sock",
+			Remote{
+				UserAddress:  "2375->unix:/var/run/docker.sock",
+				LocalHost:    "0.0.0.0",
+				LocalPort:    "2375",
+				RemoteSocket: "/var/run/docker.sock",
+				Reverse:      true,
+				LocalProto:   "tcp",
+				RemoteProto:  "tcp",
+			},
+			"0.0.0.0:2375->unix:/var/run/docker.sock",
+		},
+		{
+			"443->8443?tls=passthrough&sni=app.example.com&cert=app.crt&key=app.key",
+			Remote{
+				UserAddress: "443->8443?tls=passthrough&sni=app.example.com&cert=app.crt&key=app.key",
+				LocalPort:   "443",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "8443",
+				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+				TLS:         TLSPassthrough,
+				ServerName:  "app.example.com",
+				CertFile:    "app.crt",
+				KeyFile:     "app.key",
+			},
+			"0.0.0.0:443->127.0.0.1:8443?tls=passthrough&sni=app.example.com",
+		},
+		{
+			"443->grpc.internal:50051?alpn=h2,http/1.1&upstream=tls",
+			Remote{
+				UserAddress: "443->grpc.internal:50051?alpn=h2,http/1.1&upstream=tls",
+				LocalPort:   "443",
+				RemoteHost:  "grpc.internal",
+				RemotePort:  "50051",
+				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+				ALPN:        []string{"h2", "http/1.1"},
+				Upstream:    UpstreamTLS,
+			},
+			"0.0.0.0:443->grpc.internal:50051?alpn=h2,http/1.1",
+		},
+		{
+			"8080->80?mode=http",
+			Remote{
+				UserAddress: "8080->80?mode=http",
+				LocalPort:   "8080",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "80",
+				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+				HTTP:        true,
+			},
+			"0.0.0.0:8080->127.0.0.1:80?mode=http",
+		},
+		{
+			"8080->80?mode=http&basic=dev:preview&token=0123456789abcdef",
+			Remote{
+				UserAddress: "8080->80?mode=http&basic=***&token=***",
+				LocalPort:   "8080",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "80",
+				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+				HTTP:        true,
+				Access:      &Access{BasicAuth: "dev:preview", Token: "0123456789abcdef"},
+			},
+			"0.0.0.0:8080->127.0.0.1:80?mode=http",
+		},
+		{
+			"5432->5432?allow=10.0.0.0/8,192.168.1.7&deny=10.9.0.0/16",
+			Remote{
+				UserAddress: "5432->5432?allow=10.0.0.0/8,192.168.1.7&deny=10.9.0.0/16",
+				LocalPort:   "5432",
+				RemoteHost:  "127.0.0.1",
+				RemotePort:  "5432",
+				Reverse:     true,
+				LocalProto:  "tcp",
+				RemoteProto: "tcp",
+				Allow:       []string{"10.0.0.0/8", "192.168.1.7"},
+				Deny:        []string{"10.9.0.0/16"},
+			},
+			"0.0.0.0:5432->127.0.0.1:5432?allow=10.0.0.0/8,192.168.1.7&deny=10.9.0.0/16",
+		},
 	} {
 		//expected defaults
 		expected := test.Output
@@ -92,3 +259,144 @@ func TestRemoteDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestRemoteDecodeUnixListener(t *testing.T) {
+	r, err := DecodeRemote("unix:/tmp/app.sock->8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LocalSocket != "/tmp/app.sock" || r.LocalPort != "" || r.RemotePort != "8080" {
+		t.Fatalf("unexpected remote %#v", r)
+	}
+	if e := r.String(); e != "unix:/tmp/app.sock->8080" {
+		t.Fatalf("unexpected string %s", e)
+	}
+	if _, err := DecodeRemote("unix:/tmp/app.sock->53/udp"); err == nil {
+		t.Fatal("expected unix sockets to reject udp")
+	}
+}
+
+func TestRemoteDecodeOptions(t *testing.T) {
+	for _, input := range []string{
+		"443->8443?foo=bar",
+		"443->8443?tls=terminate",
+		"443->8443?sni=app.example.com",
+		"L:443->8443?tls=passthrough",
+		"5353->53/udp?tls=passthrough",
+		"443->8443?tls=passthrough&cert=app.crt",
+		"443->8443?tls=passthrough&sni=*.*.example.com",
+		"443->8443?alpn=h3",
+		"L:443->8443?alpn=h2",
+		"443->8443?tls=passthrough&alpn=h2",
+		"443->8443?upstream=ssl",
+		"443->8443?mode=tcp",
+		"L:8080->80?mode=http",
+		"443->8443?tls=passthrough&mode=http",
+		"5432->5432?proxy=v3",
+		"8080->80?mode=http&proxy=v1",
+		"8080->80?basic=dev:preview",
+		"8080->80?mode=http&basic=dev",
+		"8080->80?mode=http&token=short",
+		"8080->80?mode=http&signed=short",
+		"5432->5432?allow=10.0.0.0/33",
+		"5353->53/udp?deny=10.0.0.1",
+		"share:db->5432?allow=10.0.0.1",
+	} {
+		if _, err := DecodeRemote(input); err == nil {
+			t.Fatalf("decode '%s' expected error", input)
+		}
+	}
+}
+
+func TestRemoteVerify(t *testing.T) {
+	//remotes as received by the server
+	received := func(s string) *Remote {
+		r, err := DecodeRemote(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := DecodeConfig(EncodeConfig(Config{Remotes: Remotes{r}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Remotes[0]
+	}
+	for _, input := range []string{
+		"8080->80",
+		"80:10.1.2.3->8080:localhost",
+		"5353->53/udp",
+		"1080->socks",
+		"L:5432->db.internal:5432",
+		"share:pg-alice->5432",
+		"5433->share:pg-alice",
+		"2375->unix:/var/run/docker.sock",
+		"443->8443?tls=passthrough&sni=*.example.com&cert=app.crt&key=app.key",
+		"443->grpc.internal:50051?alpn=h2,http/1.1&upstream=tls",
+		"8080->80?mode=http&basic=dev:preview&token=0123456789abcdef",
+		"5432->5432?allow=10.0.0.0/8,192.168.1.7&deny=10.9.0.0/16&proxy=v2",
+	} {
+		if err := received(input).Verify(); err != nil {
+			t.Fatalf("verify '%s' failed: %s", input, err)
+		}
+	}
+	//the acl address can't be chosen by the client
+	r := received("L:9001->80")
+	r.UserAddress = "L:9001->80"
+	r.RemoteHost, r.RemotePort = "169.254.169.254", "80"
+	if err := r.Verify(); err != nil {
+		t.Fatalf("expected a consistent remote, got %s", err)
+	}
+	if addr := r.UserAddr(); addr != "L:9001:127.0.0.1->80:169.254.169.254" {
+		t.Fatalf("unexpected acl address %s", addr)
+	}
+	for _, forge := range []func(r *Remote){
+		func(r *Remote) { r.RemoteHost = "example.com:22" },
+		func(r *Remote) { r.Socks = true },
+		func(r *Remote) { r.Share = "other" },
+		func(r *Remote) { r.LocalProto = "udp" },
+		func(r *Remote) { r.ServerName = "x&tls=passthrough" },
+		func(r *Remote) { r.Access = &Access{Token: "short"} },
+	} {
+		r := received("8080->80")
+		forge(r)
+		if err := r.Verify(); err == nil {
+			t.Fatalf("expected forged remote %#v to fail", r)
+		}
+	}
+}
+
+func TestRemoteUserAddr(t *testing.T) {
+	//the form authfile address rules are matched against
+	for input, addr := range map[string]string{
+		"8080->80":                    "8080:0.0.0.0->80:127.0.0.1",
+		"8080:0.0.0.0->80":            "8080:0.0.0.0->80:127.0.0.1",
+		"8080->example.com:80":        "8080:0.0.0.0->80:example.com",
+		"5353->53/udp":                "5353:0.0.0.0->53:127.0.0.1/udp",
+		"1080->socks":                 "1080:127.0.0.1->socks",
+		"L:5432->db.internal:5432":    "L:5432:127.0.0.1->5432:db.internal",
+		"2375->unix:/run/docker.sock": "2375:0.0.0.0->unix:/run/docker.sock",
+		"8080->80?mode=http":          "8080:0.0.0.0->80:127.0.0.1?mode=http",
+	} {
+		r, err := DecodeRemote(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.UserAddr(); got != addr {
+			t.Fatalf("expected '%s' to be checked as '%s', got '%s'", input, addr, got)
+		}
+	}
+	//a plain reverse remote, against rules written for the
+	//client's own spelling and for the server's form
+	r, _ := DecodeRemote("8080->80")
+	for rule, match := range map[string]bool{
+		"^8080->80$":                   false,
+		"^0.0.0.0:8080->":              false,
+		"^8080:0.0.0.0->80:127.0.0.1$": true,
+		"^8080:":                       true,
+		"^80[0-9]{2}":                  true,
+	} {
+		if regexp.MustCompile(rule).MatchString(r.UserAddr()) != match {
+			t.Fatalf("expected rule '%s' to match %v", rule, match)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	StoreJSON = "json"
+	StoreBolt = "bolt"
+)
+
+// ErrNoVersion is returned for revisions which aren't kept
+var ErrNoVersion = errors.New("no such authfile revision")
+
+// AuthFileVersion describes a previous version of the users
+type AuthFileVersion struct {
+	Revision int64     `json:"revision"`
+	Time     time.Time `json:"time"`
+	Users    int       `json:"users"`
+	Groups   int       `json:"groups"`
+}
+
+// UserStore persists users and groups. Reads see the
+// revision they were made at, and updates are applied
+// all together, or not at all, incrementing the revision.
+// Updates keep the version they replace.
+type UserStore interface {
+	// Get returns a user by name
+	Get(name string) (*User, bool, error)
+	// List returns all users and groups, and their revision
+	List() (*AuthFile, error)
+	// Revision returns the current revision
+	Revision() (int64, error)
+	// Update runs fn in a transaction, returning the new revision
+	Update(fn func(tx UserStoreTx) error) (int64, error)
+	// History lists the previous versions kept, newest first
+	History() ([]*AuthFileVersion, error)
+	// Version returns a previous version
+	Version(rev int64) (*AuthFile, error)
+	// Redeemed checks whether an invitation was redeemed
+	Redeemed(nonce string) (bool, error)
+	// Watch calls fn when the store may have been changed by
+	// someone else, such as an admin editing the file
+	Watch(fn func()) error
+	Close() error
+}
+
+// UserStoreTx puts and deletes users and groups in an update
+type UserStoreTx interface {
+	Put(user *User) error
+	Delete(name string) error
+	PutGroup(group *Group) error
+	DeleteGroup(name string) error
+	// Clear deletes all users and groups
+	Clear() error
+	// Redeem records an invitation as redeemed until it
+	// expires, forgetting those which already expired
+	Redeem(nonce string, until time.Time) error
+}
+
+// OpenUserStore opens the store of the given kind at path,
+// a JSON authfile when kind is empty. history is how many
+// previous versions are kept.
+func OpenUserStore(kind, path string, history int) (UserStore, error) {
+	switch kind {
+	case "", StoreJSON:
+		return NewJSONStore(path, history)
+	case StoreBolt:
+		return OpenBoltStore(path, history)
+	}
+	return nil, fmt.Errorf("unknown user store '%s' (expected %s or %s)", kind, StoreJSON, StoreBolt)
+}
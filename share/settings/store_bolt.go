@@ -0,0 +1,319 @@
+package settings
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltUsers    = []byte("users")
+	boltGroups   = []byte("groups")
+	boltMeta     = []byte("meta")
+	boltHistory  = []byte("history")
+	boltRedeemed = []byte("redeemed")
+	boltRevision = []byte("revision")
+	boltTime     = []byte("time")
+)
+
+// BoltStore keeps users and groups in an embedded
+// database, updating only the entries which change
+type BoltStore struct {
+	db      *bolt.DB
+	history int
+}
+
+// boltVersion is a previous version kept in the history bucket
+type boltVersion struct {
+	Time time.Time `json:"time"`
+	*AuthFile
+}
+
+// OpenBoltStore opens or creates the database at path, keeping
+// history previous versions. The database is locked, so only
+// one server may open it at a time.
+func OpenBoltStore(path string, history int) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open user store: %s, error: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{boltUsers, boltGroups, boltMeta, boltHistory, boltRedeemed} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, history: history}, nil
+}
+
+func boltKey(rev int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(rev))
+	return k
+}
+
+func boltRevisionOf(tx *bolt.Tx) int64 {
+	if v := tx.Bucket(boltMeta).Get(boltRevision); len(v) == 8 {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (s *BoltStore) Get(name string) (*User, bool, error) {
+	var u *User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltUsers).Get([]byte(name))
+		if v == nil {
+			return nil
+		}
+		u = &User{}
+		return json.Unmarshal(v, u)
+	})
+	return u, u != nil, err
+}
+
+func (s *BoltStore) List() (*AuthFile, error) {
+	var a *AuthFile
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = boltList(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func boltList(tx *bolt.Tx) (*AuthFile, error) {
+	a := &AuthFile{Revision: boltRevisionOf(tx), Users: []*User{}}
+	err := tx.Bucket(boltUsers).ForEach(func(k, v []byte) error {
+		u := &User{}
+		if err := json.Unmarshal(v, u); err != nil {
+			return fmt.Errorf("invalid user %s: %s", k, err)
+		}
+		a.Users = append(a.Users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Bucket(boltGroups).ForEach(func(k, v []byte) error {
+		g := &Group{}
+		if err := json.Unmarshal(v, g); err != nil {
+			return fmt.Errorf("invalid group %s: %s", k, err)
+		}
+		a.Groups = append(a.Groups, g)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *BoltStore) Revision() (int64, error) {
+	var rev int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		rev = boltRevisionOf(tx)
+		return nil
+	})
+	return rev, err
+}
+
+func (s *BoltStore) Update(fn func(tx UserStoreTx) error) (int64, error) {
+	var rev int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.keep(tx); err != nil {
+			return err
+		}
+		if err := fn(&boltTx{tx}); err != nil {
+			return err
+		}
+		rev = boltRevisionOf(tx) + 1
+		meta := tx.Bucket(boltMeta)
+		if err := meta.Put(boltRevision, boltKey(rev)); err != nil {
+			return err
+		}
+		now, err := time.Now().MarshalText()
+		if err != nil {
+			return err
+		}
+		return meta.Put(boltTime, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// keep saves the current version, with the time it was
+// written, and removes the versions beyond the retention
+func (s *BoltStore) keep(tx *bolt.Tx) error {
+	if s.history <= 0 {
+		return nil
+	}
+	a, err := boltList(tx)
+	if err != nil {
+		return err
+	}
+	v := &boltVersion{AuthFile: a}
+	if t := tx.Bucket(boltMeta).Get(boltTime); t != nil {
+		if err := v.Time.UnmarshalText(t); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	history := tx.Bucket(boltHistory)
+	if err := history.Put(boltKey(a.Revision), b); err != nil {
+		return err
+	}
+	//keys sort by revision, so the oldest come first
+	var keys [][]byte
+	c := history.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	for _, k := range keys[:max(0, len(keys)-s.history)] {
+		if err := history.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) History() ([]*AuthFileVersion, error) {
+	versions := []*AuthFileVersion{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltHistory).Cursor()
+		for k, b := c.Last(); k != nil; k, b = c.Prev() {
+			v := &boltVersion{}
+			if err := json.Unmarshal(b, v); err != nil {
+				return err
+			}
+			versions = append(versions, &AuthFileVersion{
+				Revision: v.Revision,
+				Time:     v.Time,
+				Users:    len(v.Users),
+				Groups:   len(v.Groups),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (s *BoltStore) Redeemed(nonce string) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltRedeemed).Get([]byte(nonce)) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (s *BoltStore) Version(rev int64) (*AuthFile, error) {
+	v := &boltVersion{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltHistory).Get(boltKey(rev))
+		if b == nil {
+			return ErrNoVersion
+		}
+		return json.Unmarshal(b, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.AuthFile, nil
+}
+
+// Watch does nothing, since the database
+// is locked by the server which opened it
+func (s *BoltStore) Watch(fn func()) error {
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t *boltTx) Put(user *User) error {
+	v, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return t.tx.Bucket(boltUsers).Put([]byte(user.Name), v)
+}
+
+func (t *boltTx) Delete(name string) error {
+	return t.tx.Bucket(boltUsers).Delete([]byte(name))
+}
+
+func (t *boltTx) PutGroup(group *Group) error {
+	v, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return t.tx.Bucket(boltGroups).Put([]byte(group.Name), v)
+}
+
+func (t *boltTx) DeleteGroup(name string) error {
+	return t.tx.Bucket(boltGroups).Delete([]byte(name))
+}
+
+func (t *boltTx) Redeem(nonce string, until time.Time) error {
+	b := t.tx.Bucket(boltRedeemed)
+	var expired [][]byte
+	now := time.Now()
+	err := b.ForEach(func(k, v []byte) error {
+		var u time.Time
+		if err := u.UnmarshalText(v); err != nil || !u.After(now) {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	v, err := until.MarshalText()
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(nonce), v)
+}
+
+func (t *boltTx) Clear() error {
+	for _, b := range [][]byte{boltUsers, boltGroups} {
+		if err := t.tx.DeleteBucket(b); err != nil {
+			return err
+		}
+		if _, err := t.tx.CreateBucket(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package settings
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// JSONStore keeps users and groups in a JSON authfile,
+// which is rewritten as a whole on each update. Previous
+// versions are kept in the <authfile>.history directory.
+type JSONStore struct {
+	path     string
+	history  int
+	mu       sync.Mutex
+	data     []byte
+	digest   [sha256.Size]byte
+	revision int64
+	watcher  *fsnotify.Watcher
+}
+
+// NewJSONStore reads the authfile at path, keeping
+// history previous versions
+func NewJSONStore(path string, history int) (*JSONStore, error) {
+	if path == "" {
+		return nil, errors.New("configuration file not set")
+	}
+	s := &JSONStore{path: path, history: history}
+	if _, err := s.List(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read reads the file, unless it is the one last read or
+// written. Edits which didn't bump the revision get a new one.
+// It must be called holding mu.
+func (s *JSONStore) read() (*AuthFile, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth file: %s, error: %s", s.path, err)
+	}
+	if digest := sha256.Sum256(b); digest != s.digest || s.data == nil {
+		a, err := DecodeAuthFile(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.Validate(); err != nil {
+			return nil, err
+		}
+		if a.Revision > s.revision {
+			s.revision = a.Revision
+		} else if s.data != nil {
+			s.revision++
+		}
+		s.data = b
+		s.digest = digest
+	}
+	//decode again so callers may keep what they are given
+	a, err := DecodeAuthFile(s.data)
+	if err != nil {
+		return nil, err
+	}
+	a.Revision = s.revision
+	return a, nil
+}
+
+func (s *JSONStore) Get(name string) (*User, bool, error) {
+	a, err := s.List()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, u := range a.Users {
+		if u.Name == name {
+			return u, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *JSONStore) List() (*AuthFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *JSONStore) Revision() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.read(); err != nil {
+		return 0, err
+	}
+	return s.revision, nil
+}
+
+// Update applies fn to the content of the file, then
+// replaces the file atomically
+func (s *JSONStore) Update(fn func(tx UserStoreTx) error) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+	tx := &jsonTx{users: map[string]*User{}, groups: map[string]*Group{}, redeemed: a.Redeemed}
+	for _, u := range a.Users {
+		tx.users[u.Name] = u
+	}
+	for _, g := range a.Groups {
+		tx.groups[g.Name] = g
+	}
+	if err := fn(tx); err != nil {
+		return 0, err
+	}
+	a = &AuthFile{Revision: s.revision + 1, Redeemed: tx.redeemed}
+	for _, u := range tx.users {
+		a.Users = append(a.Users, u)
+	}
+	for _, g := range tx.groups {
+		a.Groups = append(a.Groups, g)
+	}
+	sort.Slice(a.Users, func(i, j int) bool { return a.Users[i].Name < a.Users[j].Name })
+	sort.Slice(a.Groups, func(i, j int) bool { return a.Groups[i].Name < a.Groups[j].Name })
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize users: %s", err)
+	}
+	if err := s.keep(); err != nil {
+		return 0, fmt.Errorf("failed to keep auth file version: %s", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return 0, fmt.Errorf("failed to write auth file: %s, error: %s", s.path, err)
+	}
+	s.revision = a.Revision
+	s.data = data
+	s.digest = sha256.Sum256(data)
+	return s.revision, nil
+}
+
+// historyDir holds the previous versions, one file per revision
+func (s *JSONStore) historyDir() string {
+	return s.path + ".history"
+}
+
+// keep saves the current version, with the time it was
+// written, and removes the versions beyond the retention.
+// It must be called holding mu.
+func (s *JSONStore) keep() error {
+	if s.history <= 0 {
+		return nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	dir := s.historyDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, strconv.FormatInt(s.revision, 10)+".json")
+	if err := writeFileAtomic(name, s.data); err != nil {
+		return err
+	}
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	revs, err := s.revisions()
+	if err != nil {
+		return err
+	}
+	for _, rev := range revs[min(len(revs), s.history):] {
+		os.Remove(filepath.Join(dir, strconv.FormatInt(rev, 10)+".json"))
+	}
+	return nil
+}
+
+// revisions lists the kept revisions, newest first
+func (s *JSONStore) revisions() ([]int64, error) {
+	entries, err := os.ReadDir(s.historyDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	revs := []int64{}
+	for _, e := range entries {
+		if rev, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".json"), 10, 64); err == nil {
+			revs = append(revs, rev)
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i] > revs[j] })
+	return revs, nil
+}
+
+func (s *JSONStore) History() ([]*AuthFileVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revs, err := s.revisions()
+	if err != nil {
+		return nil, err
+	}
+	versions := []*AuthFileVersion{}
+	for _, rev := range revs {
+		a, t, err := s.version(rev)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &AuthFileVersion{Revision: rev, Time: t, Users: len(a.Users), Groups: len(a.Groups)})
+	}
+	return versions, nil
+}
+
+func (s *JSONStore) Redeemed(nonce string) (bool, error) {
+	a, err := s.List()
+	if err != nil {
+		return false, err
+	}
+	_, found := a.Redeemed[nonce]
+	return found, nil
+}
+
+func (s *JSONStore) Version(rev int64) (*AuthFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, _, err := s.version(rev)
+	return a, err
+}
+
+func (s *JSONStore) version(rev int64) (*AuthFile, time.Time, error) {
+	name := filepath.Join(s.historyDir(), strconv.FormatInt(rev, 10)+".json")
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, ErrNoVersion
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	a, err := DecodeAuthFile(b)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid version %d: %s", rev, err)
+	}
+	a.Revision = rev
+	return a, info.ModTime(), nil
+}
+
+// Watch watches the file's directory, since
+// writes replace the file rather than changing it
+func (s *JSONStore) Watch(fn func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	s.watcher = watcher
+	name := filepath.Clean(s.path)
+	go func() {
+		for e := range watcher.Events {
+			if filepath.Clean(e.Name) == name && e.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				fn()
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *JSONStore) Close() error {
+	if s.watcher != nil {
+		return s.watcher.Close()
+	}
+	return nil
+}
+
+type jsonTx struct {
+	users    map[string]*User
+	groups   map[string]*Group
+	redeemed map[string]time.Time
+}
+
+func (t *jsonTx) Put(user *User) error {
+	t.users[user.Name] = user
+	return nil
+}
+
+func (t *jsonTx) Delete(name string) error {
+	delete(t.users, name)
+	return nil
+}
+
+func (t *jsonTx) PutGroup(group *Group) error {
+	t.groups[group.Name] = group
+	return nil
+}
+
+func (t *jsonTx) DeleteGroup(name string) error {
+	delete(t.groups, name)
+	return nil
+}
+
+func (t *jsonTx) Redeem(nonce string, until time.Time) error {
+	now := time.Now()
+	redeemed := map[string]time.Time{nonce: until}
+	for n, u := range t.redeemed {
+		if u.After(now) {
+			redeemed[n] = u
+		}
+	}
+	t.redeemed = redeemed
+	return nil
+}
+
+func (t *jsonTx) Clear() error {
+	t.users = map[string]*User{}
+	t.groups = map[string]*Group{}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file next to
+// the target, then renames it over the target, so readers
+// never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
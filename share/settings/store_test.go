@@ -0,0 +1,245 @@
+package settings
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+)
+
+func TestUserStores(t *testing.T) {
+	for _, kind := range []string{StoreJSON, StoreBolt} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if kind == StoreJSON {
+				if err := os.WriteFile(path, []byte(`[{"username":"root","password":"toor1234","addresses":[".*"],"is_admin":true}]`), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store, err := OpenUserStore(kind, path, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index := NewUserIndex(cio.NewLogger("test"))
+			if err := index.LoadUsers(store); err != nil {
+				t.Fatal(err)
+			}
+			defer index.Close()
+			rev := index.Revision()
+
+			users, _ := DecodeAuthFile([]byte(`[
+				{"username":"foo","password":"bar12345","addresses":["^9001"]},
+				{"username":"ping","password":"pong1234","addresses":["^80"]}
+			]`))
+			err = index.Transaction("", func() error {
+				for _, u := range users.Users {
+					index.Set(u.Name, u)
+				}
+				index.SetGroup(&Group{Name: "ops", Roles: []string{RoleViewer}})
+				return index.WriteUsers()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index.Revision() != rev+1 {
+				t.Fatalf("expected revision %d but got %d", rev+1, index.Revision())
+			}
+			if u, found, err := store.Get("foo"); err != nil || !found || u.Pass != "bar12345" {
+				t.Fatalf("expected foo to be stored, got %v %v", u, err)
+			}
+
+			//stale revisions are refused
+			err = index.Transaction(ETag(rev), func() error {
+				t.Fatal("expected the transaction not to run")
+				return nil
+			})
+			if err != ErrConflict {
+				t.Fatalf("expected a conflict but got %v", err)
+			}
+
+			//only deleted entries go
+			err = index.Transaction(index.ETag(), func() error {
+				index.Del("ping")
+				index.DelGroup("ops")
+				return index.WriteUsers()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.Users) != len(index.List()) || len(a.Groups) != 0 || a.Revision != rev+2 {
+				t.Fatalf("unexpected store content %+v", a)
+			}
+			if _, found, _ := store.Get("ping"); found {
+				t.Fatalf("expected ping to be deleted")
+			}
+		})
+	}
+}
+
+func TestJSONStoreEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`{"revision":3,"users":[{"username":"root","password":"toor1234","addresses":[".*"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewJSONStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev, _ := store.Revision(); rev != 3 {
+		t.Fatalf("expected the file's revision but got %d", rev)
+	}
+	//an edit which keeps the revision still changes it
+	if err := os.WriteFile(path, []byte(`{"revision":3,"users":[{"username":"root","password":"edited1234","addresses":[".*"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if rev, _ := store.Revision(); rev != 4 {
+		t.Fatalf("expected revision 4 after an edit but got %d", rev)
+	}
+	rev, err := store.Update(func(tx UserStoreTx) error {
+		return tx.Put(&User{Name: "foo", Pass: "bar12345"})
+	})
+	if err != nil || rev != 5 {
+		t.Fatalf("expected revision 5 but got %d %v", rev, err)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "edited1234") || !strings.Contains(string(b), `"revision": 5`) {
+		t.Fatalf("expected the edit to be kept, got %s", b)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the file mode to be kept, got %s", info.Mode())
+	}
+}
+
+func TestUserStoreHistory(t *testing.T) {
+	for _, kind := range []string{StoreJSON, StoreBolt} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if kind == StoreJSON {
+				if err := os.WriteFile(path, []byte(`[]`), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store, err := OpenUserStore(kind, path, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			for _, name := range []string{"foo1", "foo2", "foo3"} {
+				_, err := store.Update(func(tx UserStoreTx) error {
+					return tx.Put(&User{Name: name, Pass: "bar12345"})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			//revision 0 was dropped by the retention
+			versions, err := store.History()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 2 || versions[0].Revision != 2 || versions[1].Revision != 1 || versions[0].Users != 2 {
+				t.Fatalf("unexpected history %+v %+v", versions[0], versions[1])
+			}
+			if _, err := store.Version(0); err != ErrNoVersion {
+				t.Fatalf("expected revision 0 to be dropped, got %v", err)
+			}
+			a, err := store.Version(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.Users) != 1 || a.Users[0].Name != "foo1" || a.Revision != 1 {
+				t.Fatalf("unexpected version %+v", a)
+			}
+		})
+	}
+}
+
+func TestUserStoreRedeemed(t *testing.T) {
+	for _, kind := range []string{StoreJSON, StoreBolt} {
+		t.Run(kind, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if kind == StoreJSON {
+				if err := os.WriteFile(path, []byte(`[]`), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store, err := OpenUserStore(kind, path, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			redeem := func(nonce string, until time.Time) {
+				_, err := store.Update(func(tx UserStoreTx) error {
+					return tx.Redeem(nonce, until)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			redeem("old", time.Now().Add(-time.Minute))
+			redeem("new", time.Now().Add(time.Hour))
+			//clearing the users keeps the redeemed invitations
+			if _, err := store.Update(func(tx UserStoreTx) error { return tx.Clear() }); err != nil {
+				t.Fatal(err)
+			}
+			if found, err := store.Redeemed("new"); err != nil || !found {
+				t.Fatalf("expected the invitation to be redeemed, got %v %v", found, err)
+			}
+			//expired invitations are forgotten
+			if found, err := store.Redeemed("old"); err != nil || found {
+				t.Fatalf("expected the expired invitation to be forgotten, got %v %v", found, err)
+			}
+		})
+	}
+}
+
+// failingStore refuses every update
+type failingStore struct {
+	UserStore
+}
+
+func (s failingStore) Update(fn func(tx UserStoreTx) error) (int64, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteUsersFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`[{"username":"root","password":"toor1234","addresses":[".*"]}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewJSONStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := NewUserIndex(cio.NewLogger("test"))
+	if err := index.LoadUsers(failingStore{store}); err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	err = index.Transaction("", func() error {
+		index.Set("foo", &User{Name: "foo", Pass: "bar12345"})
+		index.Del("root")
+		return index.WriteUsers()
+	})
+	if err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	//the failed changes are neither served nor written later
+	if _, found := index.Get("foo"); found {
+		t.Fatal("expected foo to be dropped")
+	}
+	if _, found := index.Get("root"); !found {
+		t.Fatal("expected root to be kept")
+	}
+	if len(index.dirtyUsers) != 0 {
+		t.Fatalf("expected no pending changes, got %v", index.dirtyUsers)
+	}
+}
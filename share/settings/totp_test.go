@@ -0,0 +1,49 @@
+package settings
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	//RFC 6238 SHA1 test vectors, truncated to 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := TOTPCode(secret, TOTPCounter(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, TOTPCounter(now)-1)
+	if step, ok := VerifyTOTP(secret, code, now); !ok || step != TOTPCounter(now)-1 {
+		t.Fatalf("expected the previous step's code to verify")
+	}
+	code, _ = TOTPCode(secret, TOTPCounter(now)-3)
+	if _, ok := VerifyTOTP(secret, code, now); ok {
+		t.Fatalf("expected an old code to be rejected")
+	}
+	if _, ok := VerifyTOTP(secret, "12345", now); ok {
+		t.Fatalf("expected a short code to be rejected")
+	}
+	uri := TOTPURI("chiSSL", "root@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/chiSSL:root@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
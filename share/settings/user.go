@@ -3,8 +3,10 @@ package settings
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -19,10 +21,32 @@ func ParseAuth(auth string) (string, string) {
 }
 
 type User struct {
-	Name    string           `json:"username"`
-	Pass    string           `json:"password"`
-	Addrs   []*regexp.Regexp `json:"addresses"`
-	IsAdmin bool             `json:"is_admin"`
+	Name       string           `json:"username"`
+	Pass       string           `json:"password"`
+	Addrs      []*regexp.Regexp `json:"addresses"`
+	IsAdmin    bool             `json:"is_admin"`
+	AllowSocks bool             `json:"allow_socks,omitempty"`
+	//Allow and Deny restrict the peers which may connect to
+	//the user's reverse remotes, in CIDR notation, on top of
+	//the lists declared by the remotes themselves
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	//TOTPSecret, once enrolled, is required as a second
+	//factor by admins calling the REST API
+	TOTPSecret string `json:"totp_secret,omitempty"`
+	//ExpiresAt, when set, is when the user stops being
+	//able to log in. Disabled users can't log in at all.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Disabled  bool       `json:"disabled,omitempty"`
+	//Roles grant REST API permissions, and members of
+	//Groups inherit their roles and access rules
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Active checks that the user is neither disabled nor expired
+func (u *User) Active(now time.Time) bool {
+	return !u.Disabled && (u.ExpiresAt == nil || now.Before(*u.ExpiresAt))
 }
 
 func (u *User) HasAccess(addr string) bool {
@@ -50,8 +74,9 @@ func (u *User) ValidateUser() error {
 		return errors.New("password must have a minimum length of 8 characters")
 	}
 
-	// Validate Addrs: each address must have a minimum length of 1
-	if len(u.Addrs) == 0 {
+	// Validate Addrs: each address must have a minimum length of 1,
+	// members of groups may inherit all their addresses
+	if len(u.Addrs) == 0 && len(u.Groups) == 0 {
 		return errors.New("at least one address must be provided")
 	}
 	for _, r := range u.Addrs {
@@ -60,6 +85,18 @@ func (u *User) ValidateUser() error {
 		}
 	}
 
+	// Validate Allow and Deny: each must be a CIDR or an IP
+	if _, err := ParseCIDRs(u.Allow); err != nil {
+		return fmt.Errorf("invalid allow list: %v", err)
+	}
+	if _, err := ParseCIDRs(u.Deny); err != nil {
+		return fmt.Errorf("invalid deny list: %v", err)
+	}
+
+	if err := validateRoles(u.Roles); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -71,3 +108,12 @@ func (u *User) ToJSON() (string, error) {
 
 	return string(jsonData), nil
 }
+
+// WithoutCredentials returns a copy of the user
+// without its password and second factor
+func (u *User) WithoutCredentials() *User {
+	c := *u
+	c.Pass = ""
+	c.TOTPSecret = ""
+	return &c
+}
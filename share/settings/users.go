@@ -1,24 +1,30 @@
 package settings
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"golang.org/x/exp/maps"
-	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/cio"
-	"github.com/fsnotify/fsnotify"
 )
 
 type Users struct {
 	sync.RWMutex
-	inner map[string]*User
+	inner  map[string]*User
+	groups map[string]*Group
 }
 
 func NewUsers() *Users {
-	return &Users{inner: map[string]*User{}}
+	return &Users{inner: map[string]*User{}, groups: map[string]*Group{}}
 }
 
 // Len returns the numbers of users
@@ -51,6 +57,15 @@ func (u *Users) Del(key string) {
 	u.Unlock()
 }
 
+// List returns all users, sorted by name
+func (u *Users) List() []*User {
+	u.RLock()
+	users := maps.Values(u.inner)
+	u.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
 // AddUser adds a users to the set
 func (u *Users) AddUser(user *User) {
 	u.Set(user.Name, user)
@@ -68,104 +83,478 @@ func (u *Users) Reset(users []*User) {
 	u.Unlock()
 }
 
-// UserIndex is a reloadable user source
+// GetGroup returns a group by name
+func (u *Users) GetGroup(name string) (*Group, bool) {
+	u.RLock()
+	g, found := u.groups[name]
+	u.RUnlock()
+	return g, found
+}
+
+// SetGroup adds or replaces a group
+func (u *Users) SetGroup(g *Group) {
+	u.Lock()
+	u.groups[g.Name] = g
+	u.Unlock()
+}
+
+// DelGroup deletes a group
+func (u *Users) DelGroup(name string) {
+	u.Lock()
+	delete(u.groups, name)
+	u.Unlock()
+}
+
+// Groups returns all groups, sorted by name
+func (u *Users) Groups() []*Group {
+	u.RLock()
+	groups := maps.Values(u.groups)
+	u.RUnlock()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// ResetGroups replaces all groups
+func (u *Users) ResetGroups(groups []*Group) {
+	m := map[string]*Group{}
+	for _, g := range groups {
+		m[g.Name] = g
+	}
+	u.Lock()
+	u.groups = m
+	u.Unlock()
+}
+
+// Members returns the names of the members of a group
+func (u *Users) Members(group string) []string {
+	u.RLock()
+	defer u.RUnlock()
+	members := []string{}
+	for _, user := range u.inner {
+		for _, g := range user.Groups {
+			if g == group {
+				members = append(members, user.Name)
+			}
+		}
+	}
+	return members
+}
+
+// Resolve returns a copy of the user which also holds the
+// roles, addresses and allow lists of its groups. Deny lists
+// are merged too, so group members can't escape them.
+func (u *Users) Resolve(user *User) *User {
+	if len(user.Groups) == 0 {
+		return user
+	}
+	r := *user
+	r.Roles = append([]string{}, user.Roles...)
+	r.Addrs = append([]*regexp.Regexp{}, user.Addrs...)
+	u.RLock()
+	defer u.RUnlock()
+	for _, name := range user.Groups {
+		g, ok := u.groups[name]
+		if !ok {
+			continue
+		}
+		r.Roles = append(r.Roles, g.Roles...)
+		r.Addrs = append(r.Addrs, g.Addrs...)
+		r.AllowSocks = r.AllowSocks || g.AllowSocks
+		if g.Allow != nil {
+			r.Allow = append(append([]string{}, r.Allow...), g.Allow...)
+		}
+		if g.Deny != nil {
+			r.Deny = append(append([]string{}, r.Deny...), g.Deny...)
+		}
+	}
+	return &r
+}
+
+// AuthFile is the content of an authfile: either a list
+// of users, or an object holding users and groups
+type AuthFile struct {
+	Revision int64    `json:"revision,omitempty"`
+	Users    []*User  `json:"users"`
+	Groups   []*Group `json:"groups,omitempty"`
+	//Redeemed holds the nonces of redeemed
+	//invitations, until they expire
+	Redeemed map[string]time.Time `json:"redeemed,omitempty"`
+}
+
+// DecodeAuthFile decodes both authfile forms
+func DecodeAuthFile(b []byte) (*AuthFile, error) {
+	a := &AuthFile{}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(b, &a.Users); err != nil {
+			return nil, errors.New("Invalid JSON: " + err.Error())
+		}
+	} else if err := json.Unmarshal(b, a); err != nil {
+		return nil, errors.New("Invalid JSON: " + err.Error())
+	}
+	return a, nil
+}
+
+// Validate validates the users and groups, and
+// that users only belong to existing groups
+func (a *AuthFile) Validate() error {
+	groups := map[string]bool{}
+	for _, g := range a.Groups {
+		if err := g.ValidateGroup(); err != nil {
+			return fmt.Errorf("invalid setting for group %s %v", g.Name, err)
+		}
+		groups[g.Name] = true
+	}
+	for _, user := range a.Users {
+		err := user.ValidateUser()
+		if err != nil {
+			return fmt.Errorf("invalid setting for user %s %v", user.Name, err)
+		}
+		for _, g := range user.Groups {
+			if !groups[g] {
+				return fmt.Errorf("invalid setting for user %s unknown group '%s'", user.Name, g)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrConflict is returned when the authfile changed since
+// the revision a caller based its change on
+var ErrConflict = errors.New("authfile was modified concurrently")
+
+// UserIndex is a reloadable user source, kept in
+// memory and persisted to a UserStore
 type UserIndex struct {
 	*cio.Logger
 	*Users
-	configFile string
+	store UserStore
+	//mu serializes reloads and transactions
+	mu       sync.Mutex
+	revision atomic.Int64
+	//changes since the last write, guarded by Users
+	dirtyUsers  map[string]bool
+	dirtyGroups map[string]bool
+	replaced    bool
+	redeemed    map[string]time.Time
 }
 
 // NewUserIndex creates a source for users
 func NewUserIndex(logger *cio.Logger) *UserIndex {
 	return &UserIndex{
-		Logger: logger.Fork("users"),
-		Users:  NewUsers(),
+		Logger:      logger.Fork("users"),
+		Users:       NewUsers(),
+		dirtyUsers:  map[string]bool{},
+		dirtyGroups: map[string]bool{},
+		redeemed:    map[string]time.Time{},
 	}
 }
 
-// LoadUsers is responsible for loading users from a file
-func (u *UserIndex) LoadUsers(configFile string) error {
-	u.configFile = configFile
-	u.Infof("Loading configuration file %s", configFile)
+// LoadUsers is responsible for loading users from a store,
+// and reloading them when the store is changed by others
+func (u *UserIndex) LoadUsers(store UserStore) error {
+	u.store = store
+	u.Infof("Loading users from %T", store)
 	if err := u.loadUserIndex(); err != nil {
 		return err
 	}
-	if err := u.addWatchEvents(); err != nil {
-		return err
+	return store.Watch(func() {
+		if changed, err := u.reload(); err != nil {
+			u.Infof("Failed to reload the users configuration: %s", err)
+		} else if changed {
+			u.Debugf("Users configuration successfully reloaded")
+		}
+	})
+}
+
+// Close closes the store
+func (u *UserIndex) Close() error {
+	if u.store == nil {
+		return nil
 	}
-	return nil
+	return u.store.Close()
+}
+
+// loadUserIndex is responsible for loading the users configuration
+func (u *UserIndex) loadUserIndex() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.load()
 }
 
-// watchEvents is responsible for watching for updates to the file and reloading
-func (u *UserIndex) addWatchEvents() error {
-	watcher, err := fsnotify.NewWatcher()
+// reload loads the users when the store's revision
+// changed, so our own writes are skipped
+func (u *UserIndex) reload() (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.reloadLocked()
+}
+
+func (u *UserIndex) reloadLocked() (bool, error) {
+	if u.store == nil {
+		return false, errors.New("configuration file not set")
+	}
+	rev, err := u.store.Revision()
 	if err != nil {
-		return err
+		return false, err
+	}
+	if rev == u.revision.Load() {
+		return false, nil
 	}
-	if err := watcher.Add(u.configFile); err != nil {
+	return true, u.load()
+}
+
+func (u *UserIndex) load() error {
+	a, err := u.store.List()
+	if err != nil {
 		return err
 	}
-	go func() {
-		for e := range watcher.Events {
-			if e.Op&fsnotify.Write != fsnotify.Write {
-				continue
-			}
-			if err := u.loadUserIndex(); err != nil {
-				u.Infof("Failed to reload the users configuration: %s", err)
-			} else {
-				u.Debugf("Users configuration successfully reloaded from: %s", u.configFile)
-			}
-		}
-	}()
+	//swap
+	u.Users.Lock()
+	u.groups = map[string]*Group{}
+	for _, g := range a.Groups {
+		u.groups[g.Name] = g
+	}
+	u.inner = map[string]*User{}
+	for _, user := range a.Users {
+		u.inner[user.Name] = user
+	}
+	u.dirtyUsers = map[string]bool{}
+	u.dirtyGroups = map[string]bool{}
+	u.replaced = false
+	u.redeemed = map[string]time.Time{}
+	u.Users.Unlock()
+	u.revision.Store(a.Revision)
 	return nil
 }
 
-// loadUserIndex is responsible for loading the users configuration
-func (u *UserIndex) loadUserIndex() error {
-	if u.configFile == "" {
-		return errors.New("configuration file not set")
+// Set a user, to be written by WriteUsers
+func (u *UserIndex) Set(key string, user *User) {
+	u.Users.Lock()
+	u.inner[key] = user
+	u.dirtyUsers[key] = true
+	u.Users.Unlock()
+}
+
+// Del deletes a user, to be written by WriteUsers
+func (u *UserIndex) Del(key string) {
+	u.Users.Lock()
+	delete(u.inner, key)
+	u.dirtyUsers[key] = true
+	u.Users.Unlock()
+}
+
+// Reset all users, to be written by WriteUsers
+func (u *UserIndex) Reset(users []*User) {
+	u.Users.Reset(users)
+	u.Users.Lock()
+	u.replaced = true
+	u.Users.Unlock()
+}
+
+// SetGroup adds or replaces a group, to be written by WriteUsers
+func (u *UserIndex) SetGroup(g *Group) {
+	u.Users.Lock()
+	u.groups[g.Name] = g
+	u.dirtyGroups[g.Name] = true
+	u.Users.Unlock()
+}
+
+// DelGroup deletes a group, to be written by WriteUsers
+func (u *UserIndex) DelGroup(name string) {
+	u.Users.Lock()
+	delete(u.groups, name)
+	u.dirtyGroups[name] = true
+	u.Users.Unlock()
+}
+
+// ResetGroups replaces all groups, to be written by WriteUsers
+func (u *UserIndex) ResetGroups(groups []*Group) {
+	u.Users.ResetGroups(groups)
+	u.Users.Lock()
+	u.replaced = true
+	u.Users.Unlock()
+}
+
+// Redeem records an invitation as redeemed until
+// it expires, to be written by WriteUsers
+func (u *UserIndex) Redeem(nonce string, until time.Time) {
+	u.Users.Lock()
+	u.redeemed[nonce] = until
+	u.Users.Unlock()
+}
+
+// Redeemed checks whether an invitation was redeemed,
+// including by a pending Redeem
+func (u *UserIndex) Redeemed(nonce string) (bool, error) {
+	if u.store == nil {
+		return false, errors.New("configuration file not set")
 	}
-	b, err := os.ReadFile(u.configFile)
-	if err != nil {
-		return fmt.Errorf("failed to read auth file: %s, error: %s", u.configFile, err)
+	u.Users.RLock()
+	_, pending := u.redeemed[nonce]
+	u.Users.RUnlock()
+	if pending {
+		return true, nil
 	}
-	var users []*User
-	if err := json.Unmarshal(b, &users); err != nil {
-		return errors.New("Invalid JSON: " + err.Error())
+	return u.store.Redeemed(nonce)
+}
+
+// History lists the previous versions kept by the store, newest first
+func (u *UserIndex) History() ([]*AuthFileVersion, error) {
+	if u.store == nil {
+		return nil, errors.New("configuration file not set")
 	}
-	for _, user := range users {
-		err := user.ValidateUser()
-		if err != nil {
-			return fmt.Errorf("invalid setting for user %s %v", user.Name, err)
+	return u.store.History()
+}
+
+// Version returns a previous version kept by the store
+func (u *UserIndex) Version(rev int64) (*AuthFile, error) {
+	if u.store == nil {
+		return nil, errors.New("configuration file not set")
+	}
+	return u.store.Version(rev)
+}
+
+// Revision returns the revision of the users
+func (u *UserIndex) Revision() int64 {
+	return u.revision.Load()
+}
+
+// ETag returns the revision of the users as an HTTP entity tag
+func (u *UserIndex) ETag() string {
+	return ETag(u.Revision())
+}
+
+// ETag formats a revision as an HTTP entity tag
+func ETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// Transaction runs fn holding the index, after picking up any
+// changes made to the store by others. When ifMatch is set, as the
+// If-Match header would be, and doesn't match the current revision,
+// ErrConflict is returned and fn isn't run. Changes made by fn
+// are written with WriteUsers.
+func (u *UserIndex) Transaction(ifMatch string, fn func() error) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.store != nil {
+		if _, err := u.reloadLocked(); err != nil {
+			return err
 		}
 	}
-	//swap
-	u.Reset(users)
-	return nil
+	if !matchETag(ifMatch, u.revision.Load()) {
+		return ErrConflict
+	}
+	return fn()
 }
 
-// WriteUsers writes the current users to the configuration file
+// matchETag checks an If-Match header against a revision
+func matchETag(ifMatch string, revision int64) bool {
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == ETag(revision) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteUsers writes the users and groups changed since the last
+// write to the store, in one update which increments the revision.
+// When the update fails, the changes are dropped by reloading the
+// store. It must be called from within Transaction.
 func (u *UserIndex) WriteUsers() error {
-	if u.configFile == "" {
+	if u.store == nil {
 		return errors.New("configuration file not set")
 	}
+	u.Users.RLock()
+	replaced := u.replaced
+	users := map[string]*User{}
+	for name := range u.dirtyUsers {
+		users[name] = u.inner[name]
+	}
+	groups := map[string]*Group{}
+	for name := range u.dirtyGroups {
+		groups[name] = u.groups[name]
+	}
+	if replaced {
+		users = maps.Clone(u.inner)
+		groups = maps.Clone(u.groups)
+	}
+	redeemed := maps.Clone(u.redeemed)
+	u.Users.RUnlock()
 
-	data, err := json.MarshalIndent(maps.Values(u.inner), "", "  ")
+	rev, err := u.store.Update(func(tx UserStoreTx) error {
+		if replaced {
+			if err := tx.Clear(); err != nil {
+				return err
+			}
+		}
+		for name, user := range users {
+			var err error
+			if user == nil {
+				err = tx.Delete(name)
+			} else {
+				err = tx.Put(user)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for name, g := range groups {
+			var err error
+			if g == nil {
+				err = tx.DeleteGroup(name)
+			} else {
+				err = tx.PutGroup(g)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for nonce, until := range redeemed {
+			if err := tx.Redeem(nonce, until); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to serialize users: %s", err)
+		//drop the unwritten changes, which would
+		//otherwise be served and written later
+		if lerr := u.load(); lerr != nil {
+			u.Infof("Failed to reload the users configuration: %s", lerr)
+		}
+		return err
 	}
+	u.Users.Lock()
+	u.dirtyUsers = map[string]bool{}
+	u.dirtyGroups = map[string]bool{}
+	u.replaced = false
+	u.redeemed = map[string]time.Time{}
+	u.Users.Unlock()
+	u.revision.Store(rev)
+	return nil
+}
 
-	// Write the JSON data to the configuration file
-	if err := os.WriteFile(u.configFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write auth file: %s, error: %s", u.configFile, err)
+// WithoutCredentials lists the users, without
+// their passwords and second factors
+func (u *Users) WithoutCredentials() []*User {
+	u.RLock()
+	defer u.RUnlock()
+	users := []*User{}
+	for _, user := range u.inner {
+		users = append(users, user.WithoutCredentials())
 	}
-
-	return nil
+	return users
 }
 
 func (u *UserIndex) ToJSON() (string, error) {
 
-	data, err := json.MarshalIndent(maps.Values(u.inner), "", "  ")
+	data, err := json.MarshalIndent(u.List(), "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("failed to serialize users: %s", err)
 	}
@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// OpenVersion is the newest channel open payload understood by
+// this build. Version 0 payloads are a bare remote (host:port),
+// later versions append a NUL byte and a JSON encoded connMeta.
+// Only the server attaches metadata, and only once the client has
+// advertised support in its config.
+const OpenVersion = 1
+
+// connMeta describes the public connection behind a channel
+type connMeta struct {
+	V int `json:"v"`
+	//ID correlates the logs of both ends of a connection
+	ID string `json:"id,omitempty"`
+	//Remote names the remote which accepted the connection
+	Remote string `json:"remote,omitempty"`
+	Src    string `json:"src,omitempty"`
+	Dst    string `json:"dst,omitempty"`
+	SNI    string `json:"sni,omitempty"`
+	//ALPN is the negotiated protocol, or the protocols
+	//offered to passthrough remotes
+	ALPN []string `json:"alpn,omitempty"`
+}
+
+// newConnMeta describes the accepted connection c
+func newConnMeta(remote string, c net.Conn) *connMeta {
+	m := &connMeta{V: OpenVersion, ID: newConnID(), Remote: remote}
+	if c != nil {
+		m.Src = c.RemoteAddr().String()
+		m.Dst = c.LocalAddr().String()
+	}
+	return m
+}
+
+// describeTLS completes the handshake of c,
+// to learn the negotiated server name and protocol
+func (m *connMeta) describeTLS(c *tls.Conn) error {
+	c.SetDeadline(time.Now().Add(settings.EnvDuration("TLS_HANDSHAKE_TIMEOUT", 10*time.Second)))
+	defer c.SetDeadline(time.Time{})
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	cs := c.ConnectionState()
+	m.SNI = cs.ServerName
+	if cs.NegotiatedProtocol != "" {
+		m.ALPN = []string{cs.NegotiatedProtocol}
+	}
+	return nil
+}
+
+// newConnID generates a short random connection ID
+func newConnID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// encodeOpen builds the payload of a channel open request, which
+// is the bare remote unless connection metadata is attached
+func encodeOpen(remote string, meta *connMeta) []byte {
+	if meta == nil {
+		return []byte(remote)
+	}
+	b, _ := json.Marshal(meta)
+	return append([]byte(remote+"\x00"), b...)
+}
+
+// decodeOpen splits a channel open payload into
+// its remote and optional connection metadata
+func decodeOpen(payload []byte) (string, *connMeta) {
+	remote, extra, found := bytes.Cut(payload, []byte{0})
+	if !found {
+		return string(payload), nil
+	}
+	meta := &connMeta{}
+	if err := json.Unmarshal(extra, meta); err != nil {
+		return string(remote), nil
+	}
+	return string(remote), meta
+}
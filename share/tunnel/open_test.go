@@ -0,0 +1,29 @@
+package tunnel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpenPayload(t *testing.T) {
+	//old peers send and expect bare remotes
+	if remote, meta := decodeOpen([]byte("127.0.0.1:3000")); remote != "127.0.0.1:3000" || meta != nil {
+		t.Fatalf("expected bare remote, got %q %v", remote, meta)
+	}
+	if b := encodeOpen("127.0.0.1:3000", nil); string(b) != "127.0.0.1:3000" {
+		t.Fatalf("expected bare payload, got %q", b)
+	}
+	meta := &connMeta{
+		V:      OpenVersion,
+		ID:     newConnID(),
+		Remote: "443->3000",
+		Src:    "203.0.113.7:5555",
+		Dst:    "10.0.0.1:443",
+		SNI:    "app.example.com",
+		ALPN:   []string{"h2"},
+	}
+	remote, got := decodeOpen(encodeOpen("127.0.0.1:3000", meta))
+	if remote != "127.0.0.1:3000" || !reflect.DeepEqual(got, meta) {
+		t.Fatalf("expected %+v, got %q %+v", meta, remote, got)
+	}
+}
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// proxyV2Sig opens every PROXY protocol v2 header
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader builds a PROXY protocol header of the given
+// version (v1 or v2) for a connection from src to dst,
+// unparsable addresses produce an UNKNOWN (LOCAL) header
+func proxyHeader(version string, meta *connMeta) []byte {
+	var src, dst netip.AddrPort
+	var err error
+	if meta != nil {
+		if src, err = netip.ParseAddrPort(meta.Src); err == nil {
+			dst, err = netip.ParseAddrPort(meta.Dst)
+		}
+	}
+	known := meta != nil && err == nil
+	//mixed address families are sent as ipv6
+	v4 := known && src.Addr().Unmap().Is4() && dst.Addr().Unmap().Is4()
+	if version == "v1" {
+		switch {
+		case !known:
+			return []byte("PROXY UNKNOWN\r\n")
+		case v4:
+			return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n",
+				src.Addr().Unmap(), dst.Addr().Unmap(), src.Port(), dst.Port()))
+		default:
+			return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n",
+				as6(src), as6(dst), src.Port(), dst.Port()))
+		}
+	}
+	b := append([]byte{}, proxyV2Sig...)
+	switch {
+	case !known:
+		//LOCAL command, unspecified family
+		return append(b, 0x20, 0x00, 0, 0)
+	case v4:
+		b = append(b, 0x21, 0x11, 0, 12)
+		s, d := src.Addr().Unmap().As4(), dst.Addr().Unmap().As4()
+		b = append(append(b, s[:]...), d[:]...)
+	default:
+		b = append(b, 0x21, 0x21, 0, 36)
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		b = append(append(b, s[:]...), d[:]...)
+	}
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	return binary.BigEndian.AppendUint16(b, dst.Port())
+}
+
+// as6 formats the address of a, mapping ipv4 into ipv6
+func as6(a netip.AddrPort) netip.Addr {
+	return netip.AddrFrom16(a.Addr().As16())
+}
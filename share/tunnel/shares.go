@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/jpillora/sizestr"
+	"golang.org/x/crypto/ssh"
+)
+
+// ShareIndex tracks the private services published by
+// tunnels, so that other tunnels may be spliced onto them
+type ShareIndex struct {
+	mu    sync.RWMutex
+	inner map[string]*share
+}
+
+type share struct {
+	owner  string
+	tunnel *Tunnel
+	remote *settings.Remote
+}
+
+// NewShareIndex creates an empty ShareIndex
+func NewShareIndex() *ShareIndex {
+	return &ShareIndex{inner: map[string]*share{}}
+}
+
+// Available checks whether the owner may publish the named share,
+// shares can only be taken over by the user who published them
+func (s *ShareIndex) Available(name, owner string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sh, found := s.inner[name]
+	return !found || sh.owner == owner
+}
+
+// Publish registers the given remote of tunnel t
+func (s *ShareIndex) Publish(owner string, t *Tunnel, remote *settings.Remote) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sh, found := s.inner[remote.Share]; found && sh.owner != owner {
+		return fmt.Errorf("share '%s' is already published", remote.Share)
+	}
+	s.inner[remote.Share] = &share{owner: owner, tunnel: t, remote: remote}
+	return nil
+}
+
+// Unpublish removes all shares published by tunnel t
+func (s *ShareIndex) Unpublish(t *Tunnel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, sh := range s.inner {
+		if sh.tunnel == t {
+			delete(s.inner, name)
+		}
+	}
+}
+
+func (s *ShareIndex) lookup(name string) (*share, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sh, found := s.inner[name]
+	return sh, found
+}
+
+// handleShare splices src onto a new channel towards the publisher
+func (t *Tunnel) handleShare(l *cio.Logger, src io.ReadWriteCloser, name string) error {
+	if t.Config.Shares == nil {
+		return errors.New("shares are not enabled")
+	}
+	sh, found := t.Config.Shares.lookup(name)
+	if !found {
+		return fmt.Errorf("share '%s' is not published", name)
+	}
+	sshConn := sh.tunnel.getSSH(context.Background())
+	if sshConn == nil {
+		return fmt.Errorf("share '%s' is not connected", name)
+	}
+	dst, reqs, err := sshConn.OpenChannel("chisel", []byte(sh.remote.Remote()))
+	if err != nil {
+		return err
+	}
+	go ssh.DiscardRequests(reqs)
+	s, r := cio.Pipe(src, dst)
+	l.Debugf("share %s: sent %s received %s", name, sizestr.ToString(s), sizestr.ToString(r))
+	return nil
+}
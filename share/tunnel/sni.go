@@ -0,0 +1,232 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/jpillora/sizestr"
+	"golang.org/x/crypto/ssh"
+)
+
+// SNIRouter shares listeners between TLS passthrough remotes,
+// routing each connection to a tunnel by its server name (SNI)
+// without decrypting it
+type SNIRouter struct {
+	*cio.Logger
+	trusted   *cnet.TrustedProxies
+	mu        sync.Mutex
+	listeners map[string]*sniListener
+}
+
+type sniListener struct {
+	net.Listener
+	routes map[string]*sniRoute
+}
+
+type sniRoute struct {
+	tunnel *Tunnel
+	remote *settings.Remote
+	filter *cnet.IPFilter
+}
+
+// NewSNIRouter creates an SNIRouter without listeners, which
+// accepts PROXY protocol headers from the trusted proxies
+func NewSNIRouter(logger *cio.Logger, trusted *cnet.TrustedProxies) *SNIRouter {
+	return &SNIRouter{
+		Logger:    logger.Fork("sni"),
+		trusted:   trusted,
+		listeners: map[string]*sniListener{},
+	}
+}
+
+// Available checks whether the remote's server name
+// is still free on its listening address
+func (s *SNIRouter) Available(remote *settings.Remote) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sl, found := s.listeners[remote.Local()]; found {
+		_, taken := sl.routes[remote.ServerName]
+		return !taken
+	}
+	return remote.CanListen()
+}
+
+// bind routes the remote's server name to tunnel t, listening
+// on the remote's address if it's the first route there
+func (s *SNIRouter) bind(t *Tunnel, remote *settings.Remote) error {
+	filter, err := remoteFilter(remote)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addr := remote.Local()
+	sl, found := s.listeners[addr]
+	if !found {
+		network := "tcp"
+		if remote.LocalSocket != "" {
+			network, addr = "unix", remote.LocalSocket
+		}
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return s.Errorf("%s: %s", network, err)
+		}
+		sl = &sniListener{Listener: s.trusted.Listener(l), routes: map[string]*sniRoute{}}
+		s.listeners[remote.Local()] = sl
+		s.Infof("Listening on %s", remote.Local())
+		go s.serve(sl)
+	}
+	if _, taken := sl.routes[remote.ServerName]; taken {
+		return fmt.Errorf("server name '%s' is already routed on %s", remote.ServerName, remote.Local())
+	}
+	sl.routes[remote.ServerName] = &sniRoute{tunnel: t, remote: remote, filter: filter}
+	return nil
+}
+
+// unbind removes the route of the remote, closing
+// the listener once it has no routes left
+func (s *SNIRouter) unbind(t *Tunnel, remote *settings.Remote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sl, found := s.listeners[remote.Local()]
+	if !found {
+		return
+	}
+	if r, ok := sl.routes[remote.ServerName]; ok && r.tunnel == t {
+		delete(sl.routes, remote.ServerName)
+	}
+	if len(sl.routes) == 0 {
+		sl.Close()
+		delete(s.listeners, remote.Local())
+	}
+}
+
+// lookup finds the route for the given server name, trying
+// the exact name, then wildcards, then the catch-all route
+func (s *SNIRouter) lookup(sl *sniListener, name string) (*sniRoute, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = strings.ToLower(name)
+	if r, ok := sl.routes[name]; ok {
+		return r, true
+	}
+	for rest := name; strings.Contains(rest, "."); {
+		_, rest, _ = strings.Cut(rest, ".")
+		if r, ok := sl.routes["*."+rest]; ok {
+			return r, true
+		}
+	}
+	r, ok := sl.routes[""]
+	return r, ok
+}
+
+func (s *SNIRouter) serve(sl *sniListener) {
+	for {
+		src, err := sl.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.Infof("Accept error: %s", err)
+			}
+			return
+		}
+		go s.route(sl, src)
+	}
+}
+
+func (s *SNIRouter) route(sl *sniListener, src net.Conn) {
+	defer src.Close()
+	src.SetReadDeadline(time.Now().Add(settings.EnvDuration("SNI_TIMEOUT", 10*time.Second)))
+	hello, src, err := peekClientHello(src)
+	if err != nil {
+		s.Debugf("Invalid client hello from %s: %s", src.RemoteAddr(), err)
+		return
+	}
+	src.SetReadDeadline(time.Time{})
+	r, found := s.lookup(sl, hello.ServerName)
+	if !found {
+		s.Debugf("No route for server name '%s'", hello.ServerName)
+		return
+	}
+	l := r.tunnel.Fork("sni#%s", r.remote.String())
+	if !r.tunnel.admit(l, r.filter, src) {
+		return
+	}
+	sshConn := r.tunnel.getSSH(context.Background())
+	if sshConn == nil {
+		l.Debugf("No remote connection")
+		return
+	}
+	var meta *connMeta
+	if r.tunnel.Config.OpenVersion > 0 || r.remote.ProxyProtocol != "" {
+		meta = newConnMeta(r.remote.String(), src)
+		meta.SNI = hello.ServerName
+		meta.ALPN = hello.SupportedProtos
+		l = r.tunnel.Fork("sni#%s id=%s", r.remote.String(), meta.ID)
+	}
+	dst, reqs, err := sshConn.OpenChannel("chisel", encodeOpen(r.remote.Remote(), meta))
+	if err != nil {
+		l.Infof("Stream error: %s", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	sent, recv := cio.Pipe(r.tunnel.capture(r.remote.String(), r.remote.RemotePort, src.RemoteAddr().String(), src), dst)
+	l.Debugf("Close %s (sent %s received %s)", hello.ServerName, sizestr.ToString(sent), sizestr.ToString(recv))
+}
+
+var errPeeked = errors.New("peeked")
+
+// peekClientHello reads the TLS ClientHello sent on conn, the
+// returned conn replays the bytes consumed while peeking
+func peekClientHello(conn net.Conn) (*tls.ClientHelloInfo, net.Conn, error) {
+	buff := &bytes.Buffer{}
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, buff)}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = &tls.ClientHelloInfo{
+				ServerName:      h.ServerName,
+				SupportedProtos: h.SupportedProtos,
+			}
+			return nil, errPeeked
+		},
+	}).Handshake()
+	if hello == nil {
+		return nil, conn, err
+	}
+	return hello, &peekedConn{Conn: conn, r: io.MultiReader(buff, conn)}, nil
+}
+
+// peekedConn is a net.Conn which replays peeked bytes
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// readOnlyConn lets a tls.Conn read a handshake,
+// without it being able to respond
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,24 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+
+	"github.com/armon/go-socks5"
+)
+
+// socksAllowList only permits SOCKS CONNECT requests
+// towards destinations inside the given networks
+type socksAllowList []*net.IPNet
+
+func (a socksAllowList) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.Command != socks5.ConnectCommand || req.DestAddr == nil {
+		return ctx, false
+	}
+	for _, n := range a {
+		if n.Contains(req.DestAddr.IP) {
+			return ctx, true
+		}
+	}
+	return ctx, false
+}
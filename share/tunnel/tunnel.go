@@ -7,12 +7,14 @@ import (
 	"errors"
 	"io"
 	"log"
+	"net"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/NextChapterSoftware/chissl/share/cio"
 	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/pcap"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/armon/go-socks5"
 	"golang.org/x/crypto/ssh"
@@ -22,14 +24,66 @@ import (
 // Config a Tunnel
 type Config struct {
 	*cio.Logger
-	Inbound   bool
-	Outbound  bool
-	Socks     bool
+	Inbound  bool
+	Outbound bool
+	//AllowOutbound optionally restricts the
+	//remotes which outbound connections may dial
+	AllowOutbound func(remote string) bool
+	Socks         bool
+	//SocksAllow optionally restricts the
+	//destinations reachable over SOCKS
+	SocksAllow []*net.IPNet
+	//Shares resolves the private services
+	//which outbound connections may splice onto
+	Shares *ShareIndex
+	//Passthrough routes reverse TLS passthrough
+	//remotes by SNI, without decrypting them
+	Passthrough *SNIRouter
+	//Targets customizes the outbound
+	//connections to the given remotes
+	Targets map[string]*Target
+	//User is the authenticated user of the
+	//tunnel, written to the access logs
+	User string
+	//Trusted accepts PROXY protocol headers on
+	//inbound listeners, and X-Forwarded-For
+	//headers sent by the trusted proxies
+	Trusted *cnet.TrustedProxies
+	//OpenVersion is the newest channel open
+	//payload understood by the peer
+	OpenVersion int
+	//Inspect optionally taps the outbound TCP
+	//connections of a remote, to observe its traffic
+	Inspect func(remote string, src io.ReadWriteCloser) io.ReadWriteCloser
+	//Redact hides credentials from the logged
+	//traffic, defaults to the built-in rules
+	Redact *cio.Redactor
+	//Filter restricts the peers which may connect
+	//to the tunnel's remotes, on top of their own lists
+	Filter *cnet.IPFilter
+	//Capture optionally writes the TCP traffic
+	//piped by the tunnel to pcapng files
+	Capture *pcap.Capture
+	//Session names the tunnel in captures
+	Session   string
 	KeepAlive time.Duration
 	TlsConf   *tls.Config
 	IsClient  bool
 }
 
+// Target customizes outbound connections to a remote
+type Target struct {
+	//TerminateTLS decrypts passthrough connections,
+	//using the client's own certificate
+	TerminateTLS *tls.Config
+	//UpstreamTLS re-encrypts connections
+	//before they reach the target
+	UpstreamTLS *tls.Config
+	//ProxyProtocol writes a PROXY protocol header
+	//(v1 or v2) with the public peer address
+	ProxyProtocol string
+}
+
 // Tunnel represents an SSH tunnel with proxy capabilities.
 // Both chisel client and server are Tunnels.
 // chisel client has a single set of remotes, whereas
@@ -48,6 +102,7 @@ type Tunnel struct {
 	//internals
 	TlsConf     *tls.Config
 	connStats   cnet.ConnCount
+	rejected    int64
 	socksServer *socks5.Server
 }
 
@@ -66,13 +121,26 @@ func New(c Config) *Tunnel {
 		if t.Logger.Debug {
 			sl = log.New(os.Stdout, "[socks]", log.Ldate|log.Ltime)
 		}
-		t.socksServer, _ = socks5.New(&socks5.Config{Logger: sl})
+		sc := &socks5.Config{Logger: sl}
+		if len(c.SocksAllow) > 0 {
+			sc.Rules = socksAllowList(c.SocksAllow)
+		}
+		t.socksServer, _ = socks5.New(sc)
 		extra += " (SOCKS enabled)"
 	}
 	t.Debugf("Created%s", extra)
 	return t
 }
 
+// capture taps src when captures are enabled, target is the
+// port of the remote's target and label describes the source
+func (t *Tunnel) capture(remote, target, label string, src io.ReadWriteCloser) io.ReadWriteCloser {
+	if t.Config.Capture == nil {
+		return src
+	}
+	return t.Config.Capture.Tap(t.Config.Session, remote, target, label, src)
+}
+
 // BindSSH provides an active SSH for use for tunnelling
 func (t *Tunnel) BindSSH(ctx context.Context, c ssh.Conn, reqs <-chan *ssh.Request, chans <-chan ssh.NewChannel) error {
 	//link ctx to ssh-conn
@@ -154,17 +222,38 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 	if !t.Inbound {
 		return errors.New("inbound connections blocked")
 	}
-	proxies := make([]*Proxy, len(remotes))
-	for i, remote := range remotes {
+	proxies := []*Proxy{}
+	routes := []*settings.Remote{}
+	defer func() {
+		for _, remote := range routes {
+			t.Passthrough.unbind(t, remote)
+		}
+	}()
+	for _, remote := range remotes {
+		passthrough := remote.TLS == settings.TLSPassthrough
+		//passthrough remotes share listeners, routed by SNI
+		if passthrough && t.Passthrough != nil {
+			if err := t.Passthrough.bind(t, remote); err != nil {
+				return err
+			}
+			routes = append(routes, remote)
+			continue
+		}
 		var tlsConf *tls.Config = nil
-		if !t.IsClient {
+		if !t.IsClient && !passthrough {
 			tlsConf = t.TlsConf
 		}
 		p, err := NewProxy(t.Logger, t, t.proxyCount, remote, tlsConf, t.IsClient)
 		if err != nil {
 			return err
 		}
-		proxies[i] = p
+		p.user = t.Config.User
+		p.trusted = t.Config.Trusted
+		p.openVersion = t.Config.OpenVersion
+		if p.tcp != nil {
+			p.tcp = t.Config.Trusted.Listener(p.tcp)
+		}
+		proxies = append(proxies, p)
 		t.proxyCount++
 	}
 	//TODO: handle tunnel close
@@ -175,6 +264,12 @@ func (t *Tunnel) BindRemotes(ctx context.Context, remotes []*settings.Remote) er
 			return p.Run(ctx)
 		})
 	}
+	if len(routes) > 0 {
+		eg.Go(func() error {
+			<-ctx.Done()
+			return nil
+		})
+	}
 	t.Debugf("Bound proxies")
 	err := eg.Wait()
 	t.Debugf("Unbound proxies")
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/cnet"
 	"github.com/NextChapterSoftware/chissl/share/settings"
 	"github.com/jpillora/sizestr"
 	"golang.org/x/crypto/ssh"
@@ -16,6 +17,9 @@ import (
 // sshTunnel exposes a subset of Tunnel to subtypes
 type sshTunnel interface {
 	getSSH(ctx context.Context) ssh.Conn
+	capture(remote, target, label string, src io.ReadWriteCloser) io.ReadWriteCloser
+	admit(l *cio.Logger, filter *cnet.IPFilter, c net.Conn) bool
+	admitDatagram(l *cio.Logger, addr net.Addr) bool
 }
 
 // Proxy is the inbound portion of a Tunnel
@@ -26,21 +30,33 @@ type Proxy struct {
 	count    int
 	remote   *settings.Remote
 	dialer   net.Dialer
-	tcp      *net.TCPListener
+	tcp      net.Listener
+	udp      *udpListener
 	https    net.Listener
 	tlsConf  *tls.Config
 	mu       sync.Mutex
 	isClient bool
+	user     string
+	trusted  *cnet.TrustedProxies
+	filter   *cnet.IPFilter
+	//openVersion is the newest channel
+	//open payload understood by the peer
+	openVersion int
 }
 
 // NewProxy creates a Proxy
 func NewProxy(logger *cio.Logger, sshTun sshTunnel, index int, remote *settings.Remote, tlsConf *tls.Config, isClient bool) (*Proxy, error) {
 	id := index + 1
+	filter, err := remoteFilter(remote)
+	if err != nil {
+		return nil, err
+	}
 	p := &Proxy{
 		Logger:   logger.Fork("proxy#%s", remote.String()),
 		sshTun:   sshTun,
 		id:       id,
 		remote:   remote,
+		filter:   filter,
 		tlsConf:  tlsConf,
 		isClient: isClient,
 	}
@@ -48,13 +64,25 @@ func NewProxy(logger *cio.Logger, sshTun sshTunnel, index int, remote *settings.
 }
 
 func (p *Proxy) listen() error {
-	remotePort := p.remote.LocalPort
-	// If the tunnel is on the client side, we don't care just grab any port!
-	// I spent 6 hours of my life on this which I will never get back!
-	if p.isClient && p.remote.Reverse {
-		remotePort = "0"
+	if p.remote.IsUDP() {
+		u, err := listenUDP(p.Logger, p.sshTun, p.remote)
+		if err != nil {
+			return err
+		}
+		p.Infof("Listening")
+		p.udp = u
+		return nil
 	}
-	addr, err := net.ResolveTCPAddr("tcp", p.remote.LocalHost+":"+remotePort)
+	if p.remote.LocalSocket != "" {
+		l, err := net.Listen("unix", p.remote.LocalSocket)
+		if err != nil {
+			return p.Errorf("unix: %s", err)
+		}
+		p.Infof("Listening")
+		p.tcp = l
+		return nil
+	}
+	addr, err := net.ResolveTCPAddr("tcp", p.remote.Local())
 	if err != nil {
 		return p.Errorf("resolve: %s", err)
 	}
@@ -70,6 +98,12 @@ func (p *Proxy) listen() error {
 // Run enables the proxy and blocks while its active,
 // close the proxy by cancelling the context.
 func (p *Proxy) Run(ctx context.Context) error {
+	if p.udp != nil {
+		return p.udp.run(ctx)
+	}
+	if p.remote.HTTP {
+		return p.runHTTP(ctx)
+	}
 	if p.tlsConf != nil {
 		return p.runHTTPS(ctx)
 	}
@@ -104,8 +138,7 @@ func (p *Proxy) runTCP(ctx context.Context) error {
 }
 
 func (p *Proxy) runHTTPS(ctx context.Context) error {
-	p.tlsConf.NextProtos = []string{"http/1.1"}
-	p.https = tls.NewListener(p.tcp, p.tlsConf)
+	p.https = tls.NewListener(p.tcp, p.listenerTLS())
 	p.Infof("Done setting up certs and listener https listener on %s", p.tcp.Addr().String())
 
 	done := make(chan struct{})
@@ -134,7 +167,19 @@ func (p *Proxy) runHTTPS(ctx context.Context) error {
 	}
 }
 
-func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
+// listenerTLS clones the tls config for this remote, so that
+// each remote negotiates its own protocols, leaving the
+// server's shared tls config untouched
+func (p *Proxy) listenerTLS() *tls.Config {
+	conf := p.tlsConf.Clone()
+	conf.NextProtos = []string{"http/1.1"}
+	if p.remote.ALPN != nil {
+		conf.NextProtos = p.remote.ALPN
+	}
+	return conf
+}
+
+func (p *Proxy) pipeRemote(ctx context.Context, src net.Conn) {
 	defer src.Close()
 
 	p.mu.Lock()
@@ -143,20 +188,35 @@ func (p *Proxy) pipeRemote(ctx context.Context, src io.ReadWriteCloser) {
 	p.mu.Unlock()
 
 	l := p.Fork("conn#%d", cid)
-	l.Debugf("Open")
+	if !p.sshTun.admit(l, p.filter, src) {
+		return
+	}
+	//describe the public connection, when the client understands it
+	var meta *connMeta
+	if p.openVersion > 0 || p.remote.ProxyProtocol != "" {
+		meta = newConnMeta(p.remote.String(), src)
+		l = p.Fork("conn#%d id=%s", cid, meta.ID)
+		if tc, ok := src.(*tls.Conn); ok {
+			if err := meta.describeTLS(tc); err != nil {
+				l.Debugf("Handshake error: %s", err)
+				return
+			}
+		}
+	}
+	l.Debugf("Open %s", src.RemoteAddr())
 	sshConn := p.sshTun.getSSH(ctx)
 	if sshConn == nil {
 		l.Debugf("No remote connection")
 		return
 	}
 	//ssh request for tcp connection for this proxy's remote
-	dst, reqs, err := sshConn.OpenChannel("chisel", []byte(p.remote.Remote()))
+	dst, reqs, err := sshConn.OpenChannel("chisel", encodeOpen(p.remote.Remote(), meta))
 	if err != nil {
 		l.Infof("Stream error: %s", err)
 		return
 	}
 	go ssh.DiscardRequests(reqs)
 	//then pipe
-	s, r := cio.Pipe(src, dst)
+	s, r := cio.Pipe(p.sshTun.capture(p.remote.String(), p.remote.RemotePort, src.RemoteAddr().String(), src), dst)
 	l.Debugf("Close (sent %s received %s)", sizestr.ToString(s), sizestr.ToString(r))
 }
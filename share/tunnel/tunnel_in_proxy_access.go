@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// accessCookie holds a signed token, once exchanged
+const accessCookie = "chissl_access"
+
+// protect enforces the access rules of the remote, removing
+// the tunnel's credentials before requests reach the target
+func (p *Proxy) protect(next http.Handler) http.Handler {
+	access := p.remote.Access
+	if access == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		//signed links are exchanged for a cookie
+		if token := r.URL.Query().Get(settings.AccessParam); token != "" {
+			expires, ok := access.AllowSigned(token, now)
+			if !ok {
+				p.Debugf("Invalid signed token from %s", p.trusted.ClientIP(r))
+				http.Error(w, "Link expired or invalid", http.StatusForbidden)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     accessCookie,
+				Value:    token,
+				Path:     "/",
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			r.URL.RawQuery = withoutParam(r.URL.RawQuery, settings.AccessParam)
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				http.Redirect(w, r, r.URL.RequestURI(), http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, withoutAccessCookie(r))
+			return
+		}
+		if c, err := r.Cookie(accessCookie); err == nil {
+			if _, ok := access.AllowSigned(c.Value, now); ok {
+				next.ServeHTTP(w, withoutAccessCookie(r))
+				return
+			}
+		}
+		if user, pass, ok := r.BasicAuth(); ok && access.AllowBasic(user, pass) {
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && access.AllowToken(token) {
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if access.BasicAuth != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chissl", charset="UTF-8"`)
+		} else if access.Token != "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chissl"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// withoutParam removes a parameter from a raw query, leaving
+// the order and escaping of the others as the link had them
+func withoutParam(rawQuery, name string) string {
+	var kept []string
+	for _, kv := range strings.Split(rawQuery, "&") {
+		k, _, _ := strings.Cut(kv, "=")
+		if k, err := url.QueryUnescape(k); err == nil && k == name {
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return strings.Join(kept, "&")
+}
+
+// withoutAccessCookie removes the access cookie from r
+func withoutAccessCookie(r *http.Request) *http.Request {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != accessCookie {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
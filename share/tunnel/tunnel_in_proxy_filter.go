@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+// remoteFilter builds the ip filter declared by a remote,
+// nil when it declares none
+func remoteFilter(r *settings.Remote) (*cnet.IPFilter, error) {
+	if r.Allow == nil && r.Deny == nil {
+		return nil, nil
+	}
+	allow, err := settings.ParseCIDRs(r.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %s", err)
+	}
+	deny, err := settings.ParseCIDRs(r.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %s", err)
+	}
+	return &cnet.IPFilter{Allow: allow, Deny: deny}, nil
+}
+
+// admit checks the peer of c against the filters of the
+// tunnel's user and of the remote, counting and logging
+// the rejected connections
+func (t *Tunnel) admit(l *cio.Logger, filter *cnet.IPFilter, c net.Conn) bool {
+	addr := c.RemoteAddr()
+	if t.Config.Filter.Permits(addr) && filter.Permits(addr) {
+		return true
+	}
+	n := atomic.AddInt64(&t.rejected, 1)
+	l.Infof("Rejected connection from %s (%d rejected)", addr, n)
+	return false
+}
+
+// admitDatagram checks the sender of a datagram against the
+// filter of the tunnel's user, since udp remotes declare none.
+// Rejected datagrams are only logged when debugging, as they
+// may arrive in floods.
+func (t *Tunnel) admitDatagram(l *cio.Logger, addr net.Addr) bool {
+	if t.Config.Filter.Permits(addr) {
+		return true
+	}
+	n := atomic.AddInt64(&t.rejected, 1)
+	l.Debugf("Dropped datagram from %s (%d rejected)", addr, n)
+	return false
+}
+
+// admitListener checks each connection's peer on its first
+// read, so rejections close the connection in its own goroutine
+// rather than surfacing as Accept errors, which would end Serve.
+// Any PROXY header has already been read by the listener beneath.
+type admitListener struct {
+	net.Listener
+	admit func(net.Conn) bool
+}
+
+func (l *admitListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &admitConn{Conn: c, admit: l.admit}, nil
+}
+
+type admitConn struct {
+	net.Conn
+	admit func(net.Conn) bool
+	once  sync.Once
+	err   error
+}
+
+func (c *admitConn) Read(b []byte) (int, error) {
+	c.once.Do(func() {
+		if !c.admit(c.Conn) {
+			c.err = net.ErrClosed
+			c.Conn.Close()
+		}
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,175 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/jpillora/sizestr"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/http2"
+)
+
+// runHTTP serves the remote as a reverse proxy, so that requests
+// reach the target carrying the caller's address and scheme,
+// and each request is written to the access log
+func (p *Proxy) runHTTP(ctx context.Context) error {
+	host := p.remote.RemoteHost + ":" + p.remote.RemotePort
+	if p.remote.RemoteSocket != "" {
+		host = "localhost"
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return p.dialRemote(ctx)
+		},
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	defer transport.CloseIdleConnections()
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(&url.URL{Scheme: "http", Host: host})
+			//queries reach the target as sent, only those which
+			//don't parse are re-encoded, as the go version this
+			//module declares would otherwise re-encode them all
+			if _, err := url.ParseQuery(pr.In.URL.RawQuery); err == nil {
+				pr.Out.URL.RawQuery = pr.In.URL.RawQuery
+			}
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+			p.trustForwarded(pr)
+			pr.Out.Header.Set("Forwarded", forwarded(pr.In, p.trusted.ClientIP(pr.In)))
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			p.Debugf("Proxy error: %s", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	srv := &http.Server{
+		Handler:           p.accessLog(p.protect(rp)),
+		ReadHeaderTimeout: settings.EnvDuration("HTTP_HEADER_TIMEOUT", 10*time.Second),
+		IdleTimeout:       settings.EnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+	//peers are checked by the connection's goroutine
+	var l net.Listener = &admitListener{Listener: p.tcp, admit: func(c net.Conn) bool {
+		return p.sshTun.admit(p.Logger, p.filter, c)
+	}}
+	if p.tlsConf != nil {
+		conf := p.listenerTLS()
+		for _, proto := range conf.NextProtos {
+			if proto == "h2" {
+				http2.ConfigureServer(srv, nil)
+			}
+		}
+		l = tls.NewListener(l, conf)
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// dialRemote opens a channel to the proxy's remote
+func (p *Proxy) dialRemote(ctx context.Context) (net.Conn, error) {
+	sshConn := p.sshTun.getSSH(ctx)
+	if sshConn == nil {
+		return nil, errors.New("no remote connection")
+	}
+	//pooled connections carry many requests, so only
+	//the remote is described, not the public connection
+	var meta *connMeta
+	if p.openVersion > 0 {
+		meta = newConnMeta(p.remote.String(), nil)
+	}
+	dst, reqs, err := sshConn.OpenChannel("chisel", encodeOpen(p.remote.Remote(), meta))
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	return cnet.NewRWCConn(dst), nil
+}
+
+// accessLog writes one line per request
+func (p *Proxy) accessLog(next http.Handler) http.Handler {
+	user := p.user
+	if user == "" {
+		user = "-"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t0 := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		ip := p.trusted.ClientIP(r)
+		p.Infof("%s %s %s %s %s %d %s %s user=%s", ip, r.Proto, r.Method, r.Host, r.URL.RequestURI(),
+			sw.status, sizestr.ToString(sw.size), time.Since(t0).Round(time.Millisecond), user)
+	})
+}
+
+// trustForwarded keeps the forwarding headers set by
+// trusted proxies in front of the server
+func (p *Proxy) trustForwarded(pr *httputil.ProxyRequest) {
+	host, _, _ := net.SplitHostPort(pr.In.RemoteAddr)
+	if !p.trusted.Trusts(&net.TCPAddr{IP: net.ParseIP(host)}) {
+		return
+	}
+	if prior := pr.In.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		pr.Out.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+host)
+	}
+	for _, h := range []string{"X-Forwarded-Proto", "X-Forwarded-Host"} {
+		if v := pr.In.Header.Get(h); v != "" {
+			pr.Out.Header.Set(h, v)
+		}
+	}
+}
+
+// forwarded builds an RFC 7239 Forwarded header
+func forwarded(r *http.Request, ip string) string {
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	return fmt.Sprintf("for=%q;host=%q;proto=%s", ip, r.Host, proto)
+}
+
+// statusWriter records the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap exposes flushing and hijacking to http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
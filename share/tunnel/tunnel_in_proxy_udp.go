@@ -0,0 +1,203 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/jpillora/sizestr"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sync/errgroup"
+)
+
+// listenUDP is a special listener which forwards packets via
+// the bound ssh connection. the tricky part is multiplexing lots
+// of udp peers through the entry node. all datagrams share a single
+// ssh channel and are tagged with their source address, so that
+// replies from the exit node can be routed back to the right peer:
+//
+//	peer-1 1111->...                       (random)
+//	peer-2 2222->... <--> udp <--> udp <--> 6345->target
+//	peer-3 3333->...    listener  handler   7543->target
+//
+// the exit node keeps one socket per source address (a flow)
+// and closes it once the flow has been idle for UDP_IDLE_TIMEOUT.
+func listenUDP(l *cio.Logger, sshTun sshTunnel, remote *settings.Remote) (*udpListener, error) {
+	a, err := net.ResolveUDPAddr("udp", remote.Local())
+	if err != nil {
+		return nil, l.Errorf("resolve: %s", err)
+	}
+	conn, err := net.ListenUDP("udp", a)
+	if err != nil {
+		return nil, l.Errorf("udp: %s", err)
+	}
+	u := &udpListener{
+		Logger:  l,
+		sshTun:  sshTun,
+		remote:  remote,
+		inbound: conn,
+		maxMTU:  settings.EnvInt("UDP_MAX_SIZE", 9012),
+	}
+	u.Debugf("UDP max size: %d bytes", u.maxMTU)
+	return u, nil
+}
+
+type udpListener struct {
+	*cio.Logger
+	sshTun      sshTunnel
+	remote      *settings.Remote
+	inbound     *net.UDPConn
+	outboundMut sync.Mutex
+	outbound    *udpChannel
+	sent, recv  int64
+	maxMTU      int
+}
+
+func (u *udpListener) run(ctx context.Context) error {
+	defer u.inbound.Close()
+	//udp doesn't accept connections,
+	//it simply forwards packets in both directions
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return u.runInbound(ctx)
+	})
+	eg.Go(func() error {
+		return u.runOutbound(ctx)
+	})
+	//unblock the outbound decoder on cancel
+	go func() {
+		<-ctx.Done()
+		u.outboundMut.Lock()
+		if u.outbound != nil {
+			u.outbound.c.Close()
+		}
+		u.outboundMut.Unlock()
+	}()
+	if err := eg.Wait(); err != nil {
+		u.Debugf("listen: %s", err)
+		return err
+	}
+	u.Debugf("Close (sent %s received %s)",
+		sizestr.ToString(atomic.LoadInt64(&u.sent)),
+		sizestr.ToString(atomic.LoadInt64(&u.recv)))
+	return nil
+}
+
+func (u *udpListener) runInbound(ctx context.Context) error {
+	buff := make([]byte, u.maxMTU)
+	for !isDone(ctx) {
+		//read from inbound udp
+		u.inbound.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := u.inbound.ReadFromUDP(buff)
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			continue
+		}
+		if err != nil {
+			return u.Errorf("read error: %w", err)
+		}
+		//the user's filter applies to each sender
+		if !u.sshTun.admitDatagram(u.Logger, addr) {
+			continue
+		}
+		//upsert ssh channel
+		uc, err := u.getUDPChan(ctx)
+		if err != nil {
+			if isDone(ctx) || strings.HasSuffix(err.Error(), "EOF") {
+				continue //dropped packet...
+			}
+			return u.Errorf("inbound-udpchan: %w", err)
+		}
+		//send over channel, including source address
+		if err := uc.encode(addr.String(), buff[:n]); err != nil {
+			if strings.HasSuffix(err.Error(), "EOF") {
+				u.unsetUDPChan(uc)
+				continue //dropped packet...
+			}
+			return u.Errorf("encode error: %w", err)
+		}
+		atomic.AddInt64(&u.sent, int64(n))
+	}
+	return nil
+}
+
+func (u *udpListener) runOutbound(ctx context.Context) error {
+	for !isDone(ctx) {
+		//upsert ssh channel
+		uc, err := u.getUDPChan(ctx)
+		if err != nil {
+			if isDone(ctx) || strings.HasSuffix(err.Error(), "EOF") {
+				continue
+			}
+			return u.Errorf("outbound-udpchan: %w", err)
+		}
+		//receive from channel, including source address
+		p := udpPacket{}
+		if err := uc.decode(&p); err != nil {
+			//outbound ssh disconnected, get new channel...
+			u.unsetUDPChan(uc)
+			if err == io.EOF || isDone(ctx) {
+				continue
+			}
+			u.Debugf("decode error: %s", err)
+			continue
+		}
+		//a bad reply only loses itself, not the relay
+		addr, err := net.ResolveUDPAddr("udp", p.Src)
+		if err != nil {
+			u.Debugf("resolve error: %s, dropping packet", err)
+			continue
+		}
+		n, err := u.inbound.WriteToUDP(p.Payload, addr)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			u.Debugf("write error: %s, dropping packet to %s", err, addr)
+			continue
+		}
+		atomic.AddInt64(&u.recv, int64(n))
+	}
+	return nil
+}
+
+func (u *udpListener) getUDPChan(ctx context.Context) (*udpChannel, error) {
+	u.outboundMut.Lock()
+	defer u.outboundMut.Unlock()
+	//cached
+	if u.outbound != nil {
+		return u.outbound, nil
+	}
+	//not cached, bind
+	sshConn := u.sshTun.getSSH(ctx)
+	if sshConn == nil {
+		return nil, fmt.Errorf("ssh-conn nil")
+	}
+	//ssh request for udp packets for this proxy's remote,
+	//the peer address is sent along with each packet
+	rwc, reqs, err := sshConn.OpenChannel("chisel", []byte(u.remote.Remote()))
+	if err != nil {
+		return nil, fmt.Errorf("ssh-chan error: %s", err)
+	}
+	go ssh.DiscardRequests(reqs)
+	u.outbound = newUDPChannel(rwc)
+	u.Debugf("Acquired channel")
+	return u.outbound, nil
+}
+
+func (u *udpListener) unsetUDPChan(uc *udpChannel) {
+	u.outboundMut.Lock()
+	defer u.outboundMut.Unlock()
+	if u.outbound == uc {
+		uc.c.Close()
+		u.outbound = nil
+		u.Debugf("Lost channel")
+	}
+}
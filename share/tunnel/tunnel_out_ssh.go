@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -36,10 +37,15 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 		ch.Reject(ssh.Prohibited, "Denied outbound connection")
 		return
 	}
-	remote := string(ch.ExtraData())
+	remote, meta := decodeOpen(ch.ExtraData())
+	if t.Config.AllowOutbound != nil && !t.Config.AllowOutbound(remote) {
+		t.Debugf("Denied outbound connection to %s", remote)
+		ch.Reject(ssh.Prohibited, "Denied outbound connection")
+		return
+	}
 	//extract protocol
-	hostPort, _ := settings.L4Proto(remote)
-	//udp := proto == "udp"
+	hostPort, proto := settings.L4Proto(remote)
+	udp := proto == "udp"
 	socks := hostPort == "socks"
 	if socks && t.socksServer == nil {
 		t.Debugf("Denied socks request, please enable socks")
@@ -54,13 +60,32 @@ func (t *Tunnel) handleSSHChannel(ch ssh.NewChannel) {
 	stream := io.ReadWriteCloser(sshChan)
 	//cnet.MeterRWC(t.Logger.Fork("sshchan"), sshChan)
 	defer stream.Close()
+	//passthrough tls may be terminated by the client itself
+	target := t.Config.Targets[remote]
+	if target != nil && target.TerminateTLS != nil {
+		stream = tls.Server(cnet.NewRWCConn(stream), target.TerminateTLS)
+	}
 	go ssh.DiscardRequests(reqs)
-	l := t.Logger.Fork("conn#%d", t.connStats.New())
+	cid := t.connStats.New()
+	l := t.Logger.Fork("conn#%d", cid)
+	from := ""
+	if meta != nil {
+		//share the server's connection id, to correlate logs
+		l = t.Logger.Fork("conn#%d id=%s", cid, meta.ID)
+		from = " from " + meta.Src
+	}
 	//ready to handle
 	t.connStats.Open()
-	l.Debugf("Open %s", t.connStats.String())
-	// Handle TCP connection
-	err = t.handleTCP(l, stream, hostPort)
+	l.Debugf("Open %s%s", t.connStats.String(), from)
+	if name, ok := strings.CutPrefix(hostPort, "share:"); ok {
+		err = t.handleShare(l, stream, name)
+	} else if socks {
+		err = t.handleSocks(stream)
+	} else if udp {
+		err = t.handleUDP(l, stream, hostPort)
+	} else {
+		err = t.handleTCP(l, stream, hostPort, target, meta)
+	}
 	t.connStats.Close()
 	errmsg := ""
 	if err != nil && !strings.HasSuffix(err.Error(), "EOF") {
@@ -73,14 +98,42 @@ func (t *Tunnel) handleSocks(src io.ReadWriteCloser) error {
 	return t.socksServer.ServeConn(cnet.NewRWCConn(src))
 }
 
-func (t *Tunnel) handleTCP(l *cio.Logger, src io.ReadWriteCloser, hostPort string) error {
-	dst, err := net.Dial("tcp", hostPort)
+func (t *Tunnel) handleTCP(l *cio.Logger, src io.ReadWriteCloser, hostPort string, target *Target, meta *connMeta) error {
+	network, addr := "tcp", hostPort
+	if path, ok := strings.CutPrefix(hostPort, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	dst, err := net.Dial(network, addr)
 	if err != nil {
 		return err
 	}
+	if target != nil && target.ProxyProtocol != "" {
+		if _, err := dst.Write(proxyHeader(target.ProxyProtocol, meta)); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	if target != nil && target.UpstreamTLS != nil {
+		var alpn []string
+		if meta != nil {
+			alpn = meta.ALPN
+		}
+		if src, dst, err = upstreamTLS(src, dst, target.UpstreamTLS, alpn); err != nil {
+			return err
+		}
+	}
+	remote, from := hostPort, ""
+	if meta != nil {
+		remote, from = meta.Remote, meta.Src
+	}
+	if t.Config.Inspect != nil {
+		src = t.Config.Inspect(remote, src)
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	src = t.capture(remote, port, from, src)
 	var s, r int64
 	if l.IsDebug() {
-		srcLogger := cio.NewLoggingReadWriteCloser(src, l, fmt.Sprintf("Host: %s ", hostPort))
+		srcLogger := cio.NewLoggingReadWriteCloser(src, l, fmt.Sprintf("Host: %s ", hostPort), t.Config.Redact)
 		defer srcLogger.Close()
 		s, r = cio.LoggingPipe(srcLogger, dst)
 	} else {
@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+func (t *Tunnel) handleUDP(l *cio.Logger, rwc io.ReadWriteCloser, hostPort string) error {
+	conns := &udpConns{
+		Logger: l,
+		m:      map[string]*udpConn{},
+	}
+	defer conns.closeAll()
+	h := &udpHandler{
+		Logger:      l,
+		hostPort:    hostPort,
+		udpChannel:  newUDPChannel(rwc),
+		udpConns:    conns,
+		maxMTU:      settings.EnvInt("UDP_MAX_SIZE", 9012),
+		maxConns:    settings.EnvInt("UDP_MAX_CONNS", 100),
+		idleTimeout: settings.EnvDuration("UDP_IDLE_TIMEOUT", 30*time.Second),
+	}
+	h.Debugf("UDP max size: %d bytes, idle timeout: %s", h.maxMTU, h.idleTimeout)
+	for {
+		if err := h.handleWrite(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+type udpHandler struct {
+	*cio.Logger
+	hostPort string
+	*udpChannel
+	*udpConns
+	maxMTU      int
+	maxConns    int
+	idleTimeout time.Duration
+}
+
+// handleWrite forwards the next packet from the ssh
+// channel to the target, using one socket per flow
+func (h *udpHandler) handleWrite() error {
+	p := udpPacket{}
+	if err := h.decode(&p); err != nil {
+		return err
+	}
+	//dial now, we know we must write, up to <max-conns> flows,
+	//a flow which fails only loses its own packets
+	conn, exists, err := h.udpConns.dial(p.Src, h.hostPort, h.maxConns)
+	if err != nil {
+		h.Debugf("dial error: %s, dropping packet from %s", err, p.Src)
+		return nil
+	}
+	if conn == nil {
+		h.Debugf("exceeded max udp flows (%d), dropping packet from %s", h.maxConns, p.Src)
+		return nil
+	}
+	//however, we don't know if we must read,
+	//so each new flow waits for replies
+	if !exists {
+		go h.handleRead(p.Src, conn)
+	}
+	conn.touch(h.idleTimeout)
+	if _, err := conn.Write(p.Payload); err != nil {
+		//such as an ICMP unreachable from an earlier packet
+		h.Debugf("write error: %s, closing flow %s", err, p.Src)
+		h.udpConns.remove(conn)
+	}
+	return nil
+}
+
+// handleRead relays replies back to the source
+// until the flow has been idle for too long
+func (h *udpHandler) handleRead(src string, conn *udpConn) {
+	//ensure connection is cleaned up
+	defer h.udpConns.remove(conn)
+	buff := make([]byte, h.maxMTU)
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			if os.IsTimeout(err) {
+				h.Debugf("flow %s idle, closing", src)
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				h.Debugf("read error: %s", err)
+			}
+			break
+		}
+		conn.touch(h.idleTimeout)
+		//encode back over ssh connection
+		if err := h.udpChannel.encode(src, buff[:n]); err != nil {
+			h.Debugf("encode error: %s", err)
+			return
+		}
+	}
+}
+
+type udpConns struct {
+	*cio.Logger
+	sync.Mutex
+	m map[string]*udpConn
+}
+
+// dial returns the socket of a flow, dialing new flows
+// unless max are open, in which case the socket is nil
+func (cs *udpConns) dial(id, addr string, max int) (*udpConn, bool, error) {
+	cs.Lock()
+	defer cs.Unlock()
+	conn, ok := cs.m[id]
+	if !ok {
+		if len(cs.m) >= max {
+			return nil, false, nil
+		}
+		c, err := net.Dial("udp", addr)
+		if err != nil {
+			return nil, false, err
+		}
+		conn = &udpConn{
+			id:   id,
+			Conn: c,
+		}
+		cs.m[id] = conn
+	}
+	return conn, ok, nil
+}
+
+// remove closes a flow, leaving any newer flow of the same source
+func (cs *udpConns) remove(conn *udpConn) {
+	cs.Lock()
+	conn.Close()
+	if cs.m[conn.id] == conn {
+		delete(cs.m, conn.id)
+	}
+	cs.Unlock()
+}
+
+func (cs *udpConns) closeAll() {
+	cs.Lock()
+	for id, conn := range cs.m {
+		conn.Close()
+		delete(cs.m, id)
+	}
+	cs.Unlock()
+}
+
+type udpConn struct {
+	id string
+	net.Conn
+}
+
+// touch pushes back the idle deadline of this flow
+func (c *udpConn) touch(idle time.Duration) {
+	c.SetReadDeadline(time.Now().Add(idle))
+}
@@ -2,8 +2,52 @@ package tunnel
 
 import (
 	"context"
+	"encoding/gob"
+	"io"
+	"sync"
 )
 
+// udpPacket is a single datagram, tagged with the
+// address of the peer which sent it to the entry node
+type udpPacket struct {
+	Src     string
+	Payload []byte
+}
+
+func init() {
+	gob.Register(&udpPacket{})
+}
+
+// udpChannel encodes/decodes udp payloads over a stream
+type udpChannel struct {
+	r  *gob.Decoder
+	wm sync.Mutex
+	w  *gob.Encoder
+	c  io.Closer
+}
+
+func newUDPChannel(rwc io.ReadWriteCloser) *udpChannel {
+	return &udpChannel{
+		r: gob.NewDecoder(rwc),
+		w: gob.NewEncoder(rwc),
+		c: rwc,
+	}
+}
+
+// encode is safe to call from multiple goroutines
+func (o *udpChannel) encode(src string, b []byte) error {
+	o.wm.Lock()
+	defer o.wm.Unlock()
+	return o.w.Encode(udpPacket{
+		Src:     src,
+		Payload: b,
+	})
+}
+
+func (o *udpChannel) decode(p *udpPacket) error {
+	return o.r.Decode(p)
+}
+
 func isDone(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
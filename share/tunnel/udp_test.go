@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NextChapterSoftware/chissl/share/cio"
+)
+
+func TestUDPMaxFlows(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conns := &udpConns{Logger: cio.NewLogger("test"), m: map[string]*udpConn{}}
+	defer conns.closeAll()
+	a, exists, err := conns.dial("10.0.0.1:1000", l.LocalAddr().String(), 1)
+	if err != nil || a == nil || exists {
+		t.Fatalf("expected a new flow, got %v %v %v", a, exists, err)
+	}
+	//over the limit, new flows are refused without a socket
+	b, _, err := conns.dial("10.0.0.2:1000", l.LocalAddr().String(), 1)
+	if err != nil || b != nil || len(conns.m) != 1 {
+		t.Fatalf("expected the flow to be refused, got %v %v (%d flows)", b, err, len(conns.m))
+	}
+	//existing flows are still served
+	if c, exists, _ := conns.dial("10.0.0.1:1000", l.LocalAddr().String(), 1); c != a || !exists {
+		t.Fatalf("expected the existing flow")
+	}
+}
+
+func TestUDPFlowErrors(t *testing.T) {
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	//each handler has its own channel, written by the returned one
+	newHandler := func(hostPort string) (*udpHandler, *udpChannel) {
+		local, remote := net.Pipe()
+		t.Cleanup(func() { local.Close() })
+		return &udpHandler{
+			Logger:      cio.NewLogger("test"),
+			hostPort:    hostPort,
+			udpChannel:  newUDPChannel(remote),
+			udpConns:    &udpConns{Logger: cio.NewLogger("test"), m: map[string]*udpConn{}},
+			maxMTU:      9012,
+			maxConns:    10,
+			idleTimeout: time.Minute,
+		}, newUDPChannel(local)
+	}
+	send := func(h *udpHandler, ch *udpChannel, src string) error {
+		go ch.encode(src, []byte("hello"))
+		return h.handleWrite()
+	}
+	//a flow which can't be dialed only drops its packet
+	h, ch := newHandler("invalid:host:port")
+	if err := send(h, ch, "10.0.0.1:1000"); err != nil {
+		t.Fatalf("expected the packet to be dropped, got %v", err)
+	}
+	//a flow which can't be written is closed alone
+	h, ch = newHandler(l.LocalAddr().String())
+	defer h.closeAll()
+	c, err := net.Dial("udp", l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	h.m["10.0.0.1:1000"] = &udpConn{id: "10.0.0.1:1000", Conn: c}
+	if err := send(h, ch, "10.0.0.1:1000"); err != nil {
+		t.Fatalf("expected the flow to be closed, got %v", err)
+	}
+	if _, ok := h.m["10.0.0.1:1000"]; ok {
+		t.Fatalf("expected the failed flow to be removed")
+	}
+	//while other flows are still served
+	if err := send(h, ch, "10.0.0.2:1000"); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 16)
+	l.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := l.ReadFrom(b); err != nil || string(b[:n]) != "hello" {
+		t.Fatalf("expected the packet to arrive, got %q %v", b[:n], err)
+	}
+}
@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// http2Preface opens every HTTP/2 connection
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// upstreamTLS re-encrypts dst, offering the target the
+// protocol spoken by src. Servers report the protocol they
+// negotiated in alpn, older servers don't, in which case h2
+// is detected by the HTTP/2 preface, otherwise http/1.1
+func upstreamTLS(src io.ReadWriteCloser, dst net.Conn, conf *tls.Config, alpn []string) (io.ReadWriteCloser, net.Conn, error) {
+	conf = conf.Clone()
+	if len(alpn) == 1 {
+		conf.NextProtos = alpn
+	} else {
+		buff := make([]byte, len(http2Preface))
+		n, err := io.ReadFull(src, buff)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			dst.Close()
+			return nil, nil, err
+		}
+		buff = buff[:n]
+		conf.NextProtos = []string{"http/1.1"}
+		if string(buff) == http2Preface {
+			conf.NextProtos = []string{"h2"}
+		}
+		src = &replayRWC{
+			Reader:      io.MultiReader(bytes.NewReader(buff), src),
+			WriteCloser: src,
+		}
+	}
+	tc := tls.Client(dst, conf)
+	if err := tc.Handshake(); err != nil {
+		dst.Close()
+		return nil, nil, err
+	}
+	return src, tc, nil
+}
+
+// replayRWC replays peeked bytes before reading on
+type replayRWC struct {
+	io.Reader
+	io.WriteCloser
+}
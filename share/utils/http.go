@@ -8,7 +8,8 @@ import (
 	"strings"
 )
 
-func HttpRequestWithBodyWithBasicAuth(method, url, body, username, password string) (string, error) {
+// HttpRequestWithBodyWithBasicAuth sends a request, setting any extra headers
+func HttpRequestWithBodyWithBasicAuth(method, url, body, username, password string, headers ...http.Header) (string, error) {
 	// Create a new request with the given URL and body
 	req, err := http.NewRequest(strings.ToUpper(method), url, strings.NewReader(body))
 	if err != nil {
@@ -19,6 +20,11 @@ func HttpRequestWithBodyWithBasicAuth(method, url, body, username, password stri
 	auth := username + ":" + password
 	encodedAuth := base64.StdEncoding.EncodeToString([]byte(auth))
 	req.Header.Set("Authorization", "Basic "+encodedAuth)
+	for _, h := range headers {
+		for k, vs := range h {
+			req.Header[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
 
 	// Create a custom http.Client with the Basic Auth header
 	client := &http.Client{}
@@ -44,7 +50,8 @@ func HttpRequestWithBodyWithBasicAuth(method, url, body, username, password stri
 	return string(b), err
 }
 
-func HttpRequestNoBodyWithBasicAuth(method, url, username, password string) (string, error) {
+// HttpRequestNoBodyWithBasicAuth sends a request, setting any extra headers
+func HttpRequestNoBodyWithBasicAuth(method, url, username, password string, headers ...http.Header) (string, error) {
 	// Create a new request
 	req, err := http.NewRequest(strings.ToUpper(method), url, nil)
 	if err != nil {
@@ -55,6 +62,11 @@ func HttpRequestNoBodyWithBasicAuth(method, url, username, password string) (str
 	auth := username + ":" + password
 	encodedAuth := base64.StdEncoding.EncodeToString([]byte(auth))
 	req.Header.Set("Authorization", "Basic "+encodedAuth)
+	for _, h := range headers {
+		for k, vs := range h {
+			req.Header[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
 
 	// Perform the request
 	client := &http.Client{}
@@ -0,0 +1,72 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+)
+
+func TestHTTPAccess(t *testing.T) {
+	//the target echoes the credentials it received
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s", r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	}))
+	defer target.Close()
+	targetPort := target.Listener.Addr().String()[len("127.0.0.1:"):]
+	tmpPort := availablePort()
+	key := "0123456789abcdef"
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + targetPort + "?mode=http&basic=dev:preview&signed=" + key},
+		})
+	defer teardown()
+	base := "http://127.0.0.1:" + tmpPort
+	get := func(c *http.Client, req *http.Request) (int, string) {
+		t.Helper()
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	//anonymous
+	req, _ := http.NewRequest("GET", base+"/app", nil)
+	if status, _ := get(http.DefaultClient, req); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
+	}
+	//basic credentials are not forwarded
+	req, _ = http.NewRequest("GET", base+"/app", nil)
+	req.SetBasicAuth("dev", "preview")
+	if status, body := get(http.DefaultClient, req); status != http.StatusOK || body != "/app||" {
+		t.Fatalf("unexpected response %d %q", status, body)
+	}
+	//signed links set a cookie, which is not forwarded either
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	//and the rest of the query reaches the target as it was
+	req, _ = http.NewRequest("GET", settings.SignedURL(base+"/app?z=1&a=%2f&x", key, time.Now().Add(time.Hour)), nil)
+	if status, body := get(browser, req); status != http.StatusOK || body != "/app?z=1&a=%2f&x||" {
+		t.Fatalf("unexpected response %d %q", status, body)
+	}
+	req, _ = http.NewRequest("GET", base+"/other", nil)
+	req.Header.Set("Cookie", "theme=dark")
+	if status, body := get(browser, req); status != http.StatusOK || body != "/other||theme=dark" {
+		t.Fatalf("unexpected response %d %q", status, body)
+	}
+	//expired links
+	req, _ = http.NewRequest("GET", settings.SignedURL(base+"/app", key, time.Now().Add(-time.Minute)), nil)
+	if status, _ := get(http.DefaultClient, req); status != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", status)
+	}
+}
@@ -61,3 +61,58 @@ func TestReverseMTLS_SSL(t *testing.T) {
 		t.Fatalf("expected exclamation mark added")
 	}
 }
+
+func TestForward(t *testing.T) {
+	tmpPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{"L:" + tmpPort + "->$FILEPORT"},
+		})
+	defer teardown()
+	//test remote (this goes through the client and out the server)
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
+
+func TestForwardDenied(t *testing.T) {
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":["^[0-9]"]}
+	]`)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{AuthFile: authFile},
+		&chclient.Config{
+			Remotes: []string{"L:" + tmpPort + "->$FILEPORT"},
+			Auth:    "foo:bar12345",
+		})
+	defer teardown()
+	//the client listens, but the server must refuse to dial
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err == nil {
+		t.Fatal("expected forward remote to be denied")
+	}
+}
+
+func TestExpiredUser(t *testing.T) {
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":[".*"],"expires_at":"2001-01-01T00:00:00Z"}
+	]`)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{AuthFile: authFile},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->$FILEPORT"},
+			Auth:    "foo:bar12345",
+		})
+	defer teardown()
+	//the server must refuse the login, so it never listens
+	if _, err := post("http://localhost:"+tmpPort, "foo"); err == nil {
+		t.Fatal("expected expired user to be refused")
+	}
+}
@@ -0,0 +1,96 @@
+package e2e_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	chshare "github.com/NextChapterSoftware/chissl/share"
+	"github.com/NextChapterSoftware/chissl/share/cnet"
+	"github.com/NextChapterSoftware/chissl/share/settings"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// forgedServer starts a server, returning its address
+func forgedServer(t *testing.T, c *chserver.Config) string {
+	server, err := chserver.NewServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		server.Wait()
+	})
+	port := availablePort()
+	if err := server.StartContext(ctx, "127.0.0.1", port); err != nil {
+		t.Fatal(err)
+	}
+	return "127.0.0.1:" + port
+}
+
+// sendConfig sends remotes as they are, as a modified client
+// could, returning the server's error ("" when accepted)
+func sendConfig(t *testing.T, addr, auth string, remotes ...*settings.Remote) string {
+	d := websocket.Dialer{Subprotocols: []string{chshare.ProtocolVersion}}
+	wsConn, _, err := d.Dial("ws://"+addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, pass := settings.ParseAuth(auth)
+	sshConn, _, _, err := ssh.NewClientConn(cnet.NewWebSocketConn(wsConn), "", &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(pass)},
+		ClientVersion:   "SSH-" + chshare.ProtocolVersion + "-client",
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sshConn.Close()
+	ok, reply, err := sshConn.SendRequest("config", true, settings.EncodeConfig(settings.Config{
+		Version: chshare.BuildVersion,
+		Remotes: remotes,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		return ""
+	}
+	return string(reply)
+}
+
+func decodeRemote(t *testing.T, s string) *settings.Remote {
+	r, err := settings.DecodeRemote(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestForgedUserAddress(t *testing.T) {
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":["->2222:127.0.0.1$"]}
+	]`)
+	addr := forgedServer(t, &chserver.Config{AuthFile: authFile})
+	//the allowed forward remote
+	if reply := sendConfig(t, addr, "foo:bar12345", decodeRemote(t, "L:9001->2222")); reply != "" {
+		t.Fatalf("expected the remote to be allowed, got '%s'", reply)
+	}
+	//claims to be the allowed remote, but targets the metadata service
+	r := decodeRemote(t, "L:9001->2222")
+	r.RemoteHost, r.RemotePort = "169.254.169.254", "80"
+	if reply := sendConfig(t, addr, "foo:bar12345", r); !strings.Contains(reply, "access to 'L:9001:127.0.0.1->80:169.254.169.254' denied") {
+		t.Fatalf("expected the forged remote to be denied, got '%s'", reply)
+	}
+	//fields which contradict each other
+	r = decodeRemote(t, "L:9001->2222")
+	r.RemoteHost = "127.0.0.1:22#"
+	if reply := sendConfig(t, addr, "foo:bar12345", r); !strings.Contains(reply, "invalid remote") {
+		t.Fatalf("expected the inconsistent remote to be refused, got '%s'", reply)
+	}
+}
@@ -0,0 +1,74 @@
+package e2e_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestHTTP2(t *testing.T) {
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConfig.Close()
+	tlsConfig.serverTLS.CA = ""
+	//targets report the protocol they were reached with
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	cleartext := httptest.NewServer(h2c.NewHandler(proto, &http2.Server{}))
+	defer cleartext.Close()
+	encrypted := httptest.NewUnstartedServer(proto)
+	encrypted.EnableHTTP2 = true
+	encrypted.StartTLS()
+	defer encrypted.Close()
+	port := func(s *httptest.Server) string {
+		return s.Listener.Addr().String()[len("127.0.0.1:"):]
+	}
+	h2cPort := availablePort()
+	tlsPort := availablePort()
+	oldPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{TLS: *tlsConfig.serverTLS},
+		&chclient.Config{
+			Remotes: []string{
+				h2cPort + "->" + port(cleartext) + "?alpn=h2,http/1.1",
+				tlsPort + "->" + port(encrypted) + "?alpn=h2&upstream=tls-insecure",
+				oldPort + "->" + port(cleartext),
+			},
+			TLS: chclient.TLSConfig{SkipVerify: true},
+		})
+	defer teardown()
+	c := http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	for port, expected := range map[string]string{
+		h2cPort: "HTTP/2.0",
+		tlsPort: "HTTP/2.0",
+		oldPort: "HTTP/1.1",
+	} {
+		resp, err := c.Get("https://localhost:" + port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := new(strings.Builder)
+		resp.Write(b)
+		resp.Body.Close()
+		if resp.Proto != expected || !strings.HasSuffix(b.String(), expected) {
+			t.Fatalf("port %s: expected %s end-to-end, got %s", port, expected, b)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package e2e_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func TestHTTPMode(t *testing.T) {
+	//the target echoes the forwarding headers it received
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s|%s",
+			r.Header.Get("X-Forwarded-For"),
+			r.Header.Get("X-Forwarded-Proto"),
+			r.Header.Get("X-Forwarded-Host"),
+			r.Header.Get("Forwarded"))
+	}))
+	defer target.Close()
+	targetPort := target.Listener.Addr().String()[len("127.0.0.1:"):]
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + targetPort + "?mode=http"},
+		})
+	defer teardown()
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:"+tmpPort+"/path", nil)
+	req.Host = "app.example.com"
+	//spoofed headers are replaced
+	req.Header.Set("X-Forwarded-For", "10.1.1.1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	expected := `127.0.0.1|http|app.example.com|for="127.0.0.1";host="app.example.com";proto=http`
+	if string(b) != expected {
+		t.Fatalf("expected\n  %s\ngot\n  %s", expected, b)
+	}
+}
@@ -0,0 +1,88 @@
+package e2e_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"github.com/NextChapterSoftware/chissl/share/inspect"
+)
+
+func TestInspect(t *testing.T) {
+	tmpPort := availablePort()
+	inspectAddr := "127.0.0.1:" + availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->$FILEPORT"},
+			Inspect: inspectAddr,
+		})
+	defer teardown()
+	result, err := post("http://localhost:"+tmpPort+"/echo", `{"a":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != `{"a":1}!` {
+		t.Fatalf("expected exclamation mark added, got %q", result)
+	}
+	//the exchange is recorded once its response is parsed
+	var list []struct {
+		ID     int64  `json:"id"`
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Status int    `json:"status"`
+	}
+	for i := 0; i < 20 && len(list) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		resp, err := http.Get("http://" + inspectAddr + "/api/exchanges?method=post&status=2xx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+	}
+	if len(list) != 1 || list[0].URL != "/echo" || list[0].Status != 200 {
+		t.Fatalf("unexpected exchanges %+v", list)
+	}
+	resp, err := http.Get("http://" + inspectAddr + "/api/exchanges?status=4xx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list = nil
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 0 {
+		t.Fatalf("expected filtered exchanges, got %+v", list)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tmpPort := availablePort()
+	dir := t.TempDir()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes:      []string{tmpPort + "->$FILEPORT"},
+			Record:       dir,
+			RecordFormat: "har",
+		})
+	defer teardown()
+	if _, err := post("http://localhost:"+tmpPort+"/hook", "event"); err != nil {
+		t.Fatal(err)
+	}
+	var list []*inspect.Exchange
+	for i := 0; i < 20 && len(list) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		var err error
+		if list, err = inspect.Load(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(list) != 1 || list[0].URL != "/hook" || string(list[0].RequestBody) != "event" ||
+		string(list[0].ResponseBody) != "event!" {
+		t.Fatalf("unexpected recording %+v", list)
+	}
+}
@@ -0,0 +1,76 @@
+package e2e_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func TestRemoteIPFilter(t *testing.T) {
+	allowedPort := availablePort()
+	deniedPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{
+				allowedPort + "->$FILEPORT?allow=127.0.0.0/8",
+				deniedPort + "->$FILEPORT?allow=10.0.0.0/8",
+			},
+		})
+	defer teardown()
+	if result, err := post("http://127.0.0.1:"+allowedPort, "foo"); err != nil || result != "foo!" {
+		t.Fatalf("expected allowed peer, got %q %v", result, err)
+	}
+	if _, err := post("http://127.0.0.1:"+deniedPort, "foo"); err == nil {
+		t.Fatal("expected peer outside the allow list to be rejected")
+	}
+}
+
+func TestUserIPFilter(t *testing.T) {
+	//the user's deny list can't be widened by the remote
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":[".*"],"deny":["127.0.0.1"]}
+	]`)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{AuthFile: authFile},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->$FILEPORT?mode=http&allow=127.0.0.1"},
+			Auth:    "foo:bar12345",
+		})
+	defer teardown()
+	if _, err := post("http://127.0.0.1:"+tmpPort, "foo"); err == nil {
+		t.Fatal("expected peer denied by the user to be rejected")
+	}
+}
+
+func TestUserIPFilterUDP(t *testing.T) {
+	echoPort, closeEcho := udpEchoServer(t)
+	defer closeEcho()
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":[".*"],"deny":["127.0.0.1"]}
+	]`)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{AuthFile: authFile},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + echoPort + "/udp"},
+			Auth:    "foo:bar12345",
+		})
+	defer teardown()
+	conn, err := net.Dial("udp", "127.0.0.1:"+tmpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("expected datagrams denied by the user to be dropped, got %d bytes", n)
+	}
+}
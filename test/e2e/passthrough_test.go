@@ -0,0 +1,79 @@
+package e2e_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func passthroughPost(port, serverName, body string) (string, error) {
+	c := http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	resp, err := c.Post("https://127.0.0.1:"+port, "text/plain", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestTLSPassthrough(t *testing.T) {
+	tlsConfig, err := newTestTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsConfig.Close()
+	//a tls endpoint on the client side
+	local := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '?'))
+	}))
+	defer local.Close()
+	localPort := local.Listener.Addr().String()[len("127.0.0.1:"):]
+	tmpPort := availablePort()
+	cert := "&cert=" + tlsConfig.serverTLS.Cert + "&key=" + tlsConfig.serverTLS.Key
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{
+				//terminated by the local service
+				tmpPort + "->" + localPort + "?tls=passthrough&sni=a.example.com",
+				//terminated by the client
+				tmpPort + "->$FILEPORT?tls=passthrough&sni=*.example.org" + cert,
+			},
+		})
+	defer teardown()
+	result, err := passthroughPost(tmpPort, "a.example.com", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo?" {
+		t.Fatalf("expected question mark added, got %q", result)
+	}
+	result, err = passthroughPost(tmpPort, "b.example.org", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added, got %q", result)
+	}
+	//unrouted server names are dropped
+	if _, err := passthroughPost(tmpPort, "c.example.net", "foo"); err == nil {
+		t.Fatal("expected unrouted server name to fail")
+	}
+}
@@ -0,0 +1,95 @@
+package e2e_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+// proxyTarget accepts a single connection and returns the
+// first n bytes it receives
+func proxyTarget(t *testing.T, n int) (string, <-chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan []byte, 1)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b := make([]byte, n)
+		n, _ := io.ReadFull(c, b)
+		got <- b[:n]
+	}()
+	return l.Addr().String()[len("127.0.0.1:"):], got
+}
+
+func dialAndSend(t *testing.T, port string) *net.TCPAddr {
+	c, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("hello"))
+	time.Sleep(100 * time.Millisecond)
+	return c.LocalAddr().(*net.TCPAddr)
+}
+
+func TestProxyProtocolV1(t *testing.T) {
+	targetPort, got := proxyTarget(t, 64)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + targetPort + "?proxy=v1"},
+		})
+	defer teardown()
+	src := dialAndSend(t, tmpPort)
+	expected := "PROXY TCP4 127.0.0.1 127.0.0.1 " +
+		strconv.Itoa(src.Port) + " " + tmpPort + "\r\nhello"
+	select {
+	case b := <-got:
+		if string(b) != expected {
+			t.Fatalf("expected %q, got %q", expected, b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no connection")
+	}
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	targetPort, got := proxyTarget(t, 28+5)
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + targetPort + "?proxy=v2"},
+		})
+	defer teardown()
+	src := dialAndSend(t, tmpPort)
+	select {
+	case b := <-got:
+		if !bytes.HasPrefix(b, []byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\x7f\x00\x00\x01\x7f\x00\x00\x01")) {
+			t.Fatalf("invalid header %q", b)
+		}
+		if p := binary.BigEndian.Uint16(b[24:]); int(p) != src.Port {
+			t.Fatalf("expected source port %d, got %d", src.Port, p)
+		}
+		if string(b[28:]) != "hello" {
+			t.Fatalf("expected payload after header, got %q", b[28:])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no connection")
+	}
+}
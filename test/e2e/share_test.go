@@ -0,0 +1,69 @@
+package e2e_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func TestShare(t *testing.T) {
+	authFile := writeTempFile(t, `[
+		{"username":"alice","password":"alice123","addresses":["^share:"]},
+		{"username":"bob","password":"bob12345","addresses":["->share:files$"]},
+		{"username":"eve","password":"eve12345","addresses":["->share:other$"]},
+		{"username":"mallory","password":"mallory1","addresses":["^L:"]}
+	]`)
+	//alice publishes the fileserver without a public port
+	publisher := &chclient.Config{
+		Remotes: []string{"share:files->$FILEPORT"},
+		Auth:    "alice:alice123",
+	}
+	teardown := simpleSetup(t, &chserver.Config{AuthFile: authFile}, publisher)
+	defer teardown()
+	//bob binds a local port to alice's share
+	consume := func(auth string) (string, func()) {
+		port := availablePort()
+		c, err := chclient.NewClient(&chclient.Config{
+			Fingerprint: publisher.Fingerprint,
+			Server:      publisher.Server,
+			Remotes:     []string{port + "->share:files"},
+			Auth:        auth,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := c.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		return port, func() {
+			cancel()
+			c.Wait()
+		}
+	}
+	bobPort, stopBob := consume("bob:bob12345")
+	defer stopBob()
+	result, err := post("http://localhost:"+bobPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//eve is not authorized to consume the share
+	evePort, stopEve := consume("eve:eve12345")
+	defer stopEve()
+	if _, err := post("http://localhost:"+evePort, "foo"); err == nil {
+		t.Fatal("expected share access to be denied")
+	}
+	//mallory may forward any port, but was never granted the share
+	malloryPort, stopMallory := consume("mallory:mallory1")
+	defer stopMallory()
+	if _, err := post("http://localhost:"+malloryPort, "foo"); err == nil {
+		t.Fatal("expected share access to be denied")
+	}
+}
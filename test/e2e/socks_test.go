@@ -0,0 +1,107 @@
+package e2e_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+	"golang.org/x/net/proxy"
+)
+
+func socksPost(socksPort, url, body string) (string, error) {
+	d, err := proxy.SOCKS5("tcp", "127.0.0.1:"+socksPort, nil, proxy.Direct)
+	if err != nil {
+		return "", err
+	}
+	client := http.Client{
+		Transport: &http.Transport{Dial: d.Dial},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Post(url, "text/plain", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func TestReverseSocks(t *testing.T) {
+	//endpoint only reachable from the client's network
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '!'))
+	}))
+	defer target.Close()
+	socksPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes:    []string{socksPort + "->socks"},
+			SocksAllow: []string{"127.0.0.1"},
+		})
+	defer teardown()
+	//dial the endpoint through the client's network
+	result, err := socksPost(socksPort, target.URL, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+	//destinations outside of the allowlist are refused
+	if _, err := socksPost(socksPort, "http://127.0.0.2:1", "bar"); err == nil {
+		t.Fatal("expected socks request outside allowlist to fail")
+	}
+	//the endpoint only listens on the loopback interface
+	if ip := externalIP(t); ip != "" {
+		if c, err := net.DialTimeout("tcp", net.JoinHostPort(ip, socksPort), time.Second); err == nil {
+			c.Close()
+			t.Fatalf("expected the socks endpoint to be unreachable on %s", ip)
+		}
+	}
+}
+
+// externalIP returns a non-loopback address of this host, if any
+func externalIP(t *testing.T) string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			return n.IP.String()
+		}
+	}
+	t.Log("no external address, skipping the interface check")
+	return ""
+}
+
+func TestReverseSocksDenied(t *testing.T) {
+	authFile := writeTempFile(t, `[
+		{"username":"foo","password":"bar12345","addresses":[".*"]}
+	]`)
+	socksPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{AuthFile: authFile},
+		&chclient.Config{
+			Remotes:       []string{socksPort + "->socks"},
+			Auth:          "foo:bar12345",
+			MaxRetryCount: 0,
+		})
+	defer teardown()
+	//user lacks allow_socks, so the server must not listen
+	if _, err := socksPost(socksPort, "http://127.0.0.1:1", "foo"); err == nil {
+		t.Fatal("expected socks endpoint to be unavailable")
+	}
+}
@@ -0,0 +1,60 @@
+package e2e_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	//the target echoes the client ip it was given
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Forwarded-For"))
+	}))
+	defer target.Close()
+	targetPort := target.Listener.Addr().String()[len("127.0.0.1:"):]
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{
+			ProxyProtocol:  true,
+			TrustedProxies: []string{"127.0.0.1"},
+		},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + targetPort + "?mode=http"},
+		})
+	defer teardown()
+	request := func(header, xff string) string {
+		c, err := net.Dial("tcp", "127.0.0.1:"+tmpPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		fmt.Fprintf(c, "%sGET / HTTP/1.1\r\nHost: test\r\n%sConnection: close\r\n\r\n", header, xff)
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	//load balancer sending the proxy protocol
+	if got := request("PROXY TCP4 203.0.113.7 127.0.0.1 5555 "+tmpPort+"\r\n", ""); got != "203.0.113.7" {
+		t.Fatalf("expected proxy protocol address, got %q", got)
+	}
+	//trusted http proxy sending x-forwarded-for
+	if got := request("", "X-Forwarded-For: 198.51.100.1\r\n"); got != "198.51.100.1, 127.0.0.1" {
+		t.Fatalf("expected forwarded chain, got %q", got)
+	}
+	//untrusted peers can't spoof x-forwarded-for
+	if got := request("PROXY TCP4 203.0.113.7 127.0.0.1 5555 "+tmpPort+"\r\n", "X-Forwarded-For: 198.51.100.1\r\n"); got != "203.0.113.7" {
+		t.Fatalf("expected spoofed header to be dropped, got %q", got)
+	}
+}
@@ -0,0 +1,63 @@
+package e2e_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func udpEchoServer(t *testing.T) (string, func()) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buff := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append(buff[:n:n], '!'), addr)
+		}
+	}()
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	return port, func() { conn.Close() }
+}
+
+func TestReverseUDP(t *testing.T) {
+	echoPort, closeEcho := udpEchoServer(t)
+	defer closeEcho()
+	tmpPort := availablePort()
+	//setup server, client, fileserver
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->" + echoPort + "/udp"},
+		})
+	defer teardown()
+	//test remote (this goes through the server and out the client)
+	conn, err := net.Dial("udp", "127.0.0.1:"+tmpPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buff := make([]byte, 64)
+	for _, msg := range []string{"foo", "bar"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buff[:n]); got != msg+"!" {
+			t.Fatalf("expected '%s!' but got '%s'", msg, got)
+		}
+	}
+}
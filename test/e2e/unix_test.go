@@ -0,0 +1,92 @@
+package e2e_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	chclient "github.com/NextChapterSoftware/chissl/client"
+	chserver "github.com/NextChapterSoftware/chissl/server"
+)
+
+func TestReverseUnixTarget(t *testing.T) {
+	//unix socket endpoint
+	sock := filepath.Join(t.TempDir(), "echo.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(append(b, '?'))
+	}))
+	tmpPort := availablePort()
+	teardown := simpleSetup(t,
+		&chserver.Config{},
+		&chclient.Config{
+			Remotes: []string{tmpPort + "->unix:" + sock},
+		})
+	defer teardown()
+	result, err := post("http://localhost:"+tmpPort, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "foo?" {
+		t.Fatalf("expected question mark added")
+	}
+}
+
+func TestReverseUnixListener(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "proxy.sock")
+	teardown := simpleSetup(t,
+		&chserver.Config{SocketDir: dir},
+		&chclient.Config{
+			Remotes: []string{"unix:" + sock + "->$FILEPORT"},
+		})
+	defer teardown()
+	c := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := c.Post("http://unix", "text/plain", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "foo!" {
+		t.Fatalf("expected exclamation mark added")
+	}
+}
+
+func TestUnixSocketDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sockets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	addr := forgedServer(t, &chserver.Config{Reverse: true, SocketDir: filepath.Join(dir, "sockets")})
+	for _, remote := range []string{
+		"unix:" + filepath.Join(dir, "proxy.sock") + "->8080",
+		"unix:" + filepath.Join(dir, "sockets", "..", "proxy.sock") + "->8080",
+		"L:2375->unix:/var/run/docker.sock",
+	} {
+		if reply := sendConfig(t, addr, "", decodeRemote(t, remote)); !strings.Contains(reply, "not permitted") {
+			t.Fatalf("expected %s to be refused, got '%s'", remote, reply)
+		}
+	}
+	//client side sockets are up to the client
+	if reply := sendConfig(t, addr, "", decodeRemote(t, availablePort()+"->unix:/var/run/docker.sock")); reply != "" {
+		t.Fatalf("expected the remote to be allowed, got '%s'", reply)
+	}
+	if reply := sendConfig(t, addr, "", decodeRemote(t, "unix:"+filepath.Join(dir, "sockets", "proxy.sock")+"->8080")); reply != "" {
+		t.Fatalf("expected the remote to be allowed, got '%s'", reply)
+	}
+}